@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// parseCIDRs parses CIDR ranges such as "10.0.0.0/8". A bare IP address is
+// accepted as a single-host range.
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			if !strings.Contains(item, "/") {
+				ip := net.ParseIP(item)
+				if ip == nil {
+					return nil, fmt.Errorf("invalid CIDR or IP %q", item)
+				}
+				bits := 128
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+			_, ipNet, err := net.ParseCIDR(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", item)
+			}
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that sent r. Behind a trusted
+// reverse proxy this is the last X-Forwarded-For entry, the one the proxy
+// itself appended; entries before it are client-supplied and can't be
+// trusted.
+func clientIP(r *http.Request, trustProxy bool) net.IP {
+	if trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := net.ParseIP(strings.TrimSpace(hops[len(hops)-1])); ip != nil {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// clientAllowed applies the --allow/--deny network rules to the client of r.
+// A deny match always wins; with an allowlist, only listed networks pass.
+func (fs *FileServer) clientAllowed(r *http.Request) bool {
+	if len(fs.allowNets) == 0 && len(fs.denyNets) == 0 {
+		return true
+	}
+	ip := clientIP(r, fs.trustProxy)
+	if ip == nil {
+		return false
+	}
+	if containsIP(fs.denyNets, ip) {
+		return false
+	}
+	return len(fs.allowNets) == 0 || containsIP(fs.allowNets, ip)
+}
+
+// requestScheme returns "https" or "http" for r. Behind a trusted proxy the
+// X-Forwarded-Proto header is honored, since TLS ends at the proxy.
+func requestScheme(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "https" || proto == "http" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// webhookKeyValid reports whether a request for relPath (as returned by
+// rootRelative) passes the shared secret check. Paths outside the
+// --webhook-prefix list are not gated.
+func (fs *FileServer) webhookKeyValid(r *http.Request, relPath string) bool {
+	for _, prefix := range fs.webhookPrefixes {
+		if hasPathPrefix(relPath, prefix) {
+			key := r.URL.Query().Get("key")
+			return subtle.ConstantTimeCompare([]byte(key), []byte(fs.webhookKey)) == 1
+		}
+	}
+	return true
+}
+
+// hasPathPrefix reports whether relPath is prefix or lies below it. A
+// trailing slash on prefix is optional, so "/hooks/" also covers "/hooks".
+func hasPathPrefix(relPath, prefix string) bool {
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	dir := strings.TrimSuffix(prefix, "/")
+	return relPath == dir || strings.HasPrefix(relPath, dir+"/")
+}
+
+// sourceMapAllowed applies --block-sourcemaps: .map files are only served to
+// clients in the --sourcemap-allow networks. Other paths always pass.
+func (fs *FileServer) sourceMapAllowed(r *http.Request, absPath string) bool {
+	if !fs.blockSourceMaps || !strings.EqualFold(filepath.Ext(absPath), ".map") {
+		return true
+	}
+	ip := clientIP(r, fs.trustProxy)
+	return ip != nil && containsIP(fs.sourceMapNets, ip)
+}
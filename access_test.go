@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBlockSourceMaps(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"app.js": "code", "app.js.map": "{}"})
+	fs := newTestServer(dir)
+	fs.blockSourceMaps = true
+
+	if w := doRequest(fs, http.MethodGet, "/app.js.map", nil); w.Code != http.StatusNotFound {
+		t.Errorf("blocked client: status %d, want 404", w.Code)
+	}
+	if w := doRequest(fs, http.MethodGet, "/app.js", nil); w.Code != http.StatusOK {
+		t.Errorf("script: status %d, want 200", w.Code)
+	}
+	w := doRequest(fs, http.MethodGet, "/?download=zip", nil)
+	if got := strings.Join(zipNames(t, w.Body.Bytes()), " "); got != "app.js" {
+		t.Errorf("archive for a blocked client holds %q", got)
+	}
+
+	// httptest requests come from 192.0.2.1
+	nets, err := parseCIDRs([]string{"192.0.2.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.sourceMapNets = nets
+	if w := doRequest(fs, http.MethodGet, "/app.js.map", nil); w.Code != http.StatusOK || w.Body.String() != "{}" {
+		t.Errorf("allowlisted client: status %d, body %q", w.Code, w.Body)
+	}
+}
+
+func TestWebhookKey(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"hooks/deploy.json": "{}", "hooks/sub/build.json": "{}", "hookshot.txt": "h"})
+	fs := newTestServer(dir)
+	fs.webhookKey = "s3cret"
+	fs.webhookPrefixes = []string{"/hooks/"}
+
+	for _, tc := range []struct {
+		target string
+		status int
+	}{
+		{"/hooks/deploy.json", http.StatusForbidden},
+		{"/hooks/deploy.json?key=guess", http.StatusForbidden},
+		{"/hooks/deploy.json?key=s3cre", http.StatusForbidden},
+		{"/hooks/deploy.json?key=s3cret", http.StatusOK},
+		{"/hooks/sub/build.json", http.StatusForbidden},
+		{"/hooks/sub/build.json?key=s3cret", http.StatusOK},
+		{"/hooks/./deploy.json", http.StatusForbidden},
+		{"/hooks", http.StatusForbidden},
+		{"/hookshot.txt", http.StatusOK}, // only whole segments match
+	} {
+		if w := doRequest(fs, http.MethodGet, tc.target, nil); w.Code != tc.status {
+			t.Errorf("GET %s: status %d, want %d", tc.target, w.Code, tc.status)
+		}
+	}
+}
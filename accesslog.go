@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IPEnricher adds extra fields, such as country or ASN, to the access log
+// line of a request from ip. Implementations are called once per request
+// and should be fast, e.g. a lookup in a local GeoIP database.
+type IPEnricher interface {
+	Enrich(ip net.IP) map[string]string
+}
+
+// noopEnricher is the default IPEnricher and adds nothing.
+type noopEnricher struct{}
+
+func (noopEnricher) Enrich(net.IP) map[string]string { return nil }
+
+// ipEnricher is consulted by withAccessLog. A build can plug in its own
+// implementation by assigning it from an init function in another file.
+var ipEnricher IPEnricher = noopEnricher{}
+
+var accessLogger = log.New(os.Stderr, "", 0)
+
+// newJSONLogger returns the --log-json logger: one JSON object per line on
+// stderr, with the time under "ts".
+func newJSONLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				attr.Key = "ts"
+			}
+			return attr
+		},
+	}))
+}
+
+// withAccessLog logs one line per request to stderr: logfmt, or a JSON
+// record through jsonLog when it is set (--log-json). The fields from
+// ipEnricher come after the standard ones. With color the logfmt status is
+// highlighted by class.
+func withAccessLog(next http.Handler, enricher IPEnricher, trustProxy, color bool, jsonLog *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newStatusRecorder(w)
+		r = withAuthUserSlot(r)
+		// Deferred, so a handler that panics, such as a download aborted
+		// with http.ErrAbortHandler, is still logged on the way out
+		defer func() {
+			ip := clientIP(r, trustProxy)
+			extra := enricher.Enrich(ip)
+			keys := make([]string, 0, len(extra))
+			for k := range extra {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			if jsonLog != nil {
+				attrs := []slog.Attr{
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.RequestURI()),
+					slog.Int("status", rec.status),
+					slog.Int64("bytes", rec.bytes),
+					slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+					slog.String("remote_ip", ipString(ip)),
+					slog.String("request_id", requestID(r)),
+					slog.String("user", authUser(r)),
+				}
+				for _, k := range keys {
+					attrs = append(attrs, slog.String(k, extra[k]))
+				}
+				jsonLog.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+				return
+			}
+
+			remote := "-"
+			if ip != nil {
+				remote = ip.String()
+			}
+			fields := []string{
+				logfmtPair("time", start.UTC().Format(time.RFC3339)),
+				logfmtPair("remote", remote),
+				logfmtPair("method", r.Method),
+				logfmtPair("path", r.URL.RequestURI()),
+				logfmtPair("status", colorStatus(color, rec.status)),
+				logfmtPair("bytes", strconv.FormatInt(rec.bytes, 10)),
+				logfmtPair("duration", time.Since(start).String()),
+				logfmtPair("request_id", requestID(r)),
+			}
+
+			for _, k := range keys {
+				fields = append(fields, logfmtPair(k, extra[k]))
+			}
+
+			accessLogger.Println(strings.Join(fields, " "))
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// ipString formats ip for the JSON log, "" when it is unknown.
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// logfmtPair formats key=value, quoting the value when it is empty or holds
+// spaces, quotes or equals signs.
+func logfmtPair(key, value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\"=") {
+		value = strconv.Quote(value)
+	}
+	return key + "=" + value
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogOnlyLogsVerifiedUsers(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"private/a.txt": "a", "public.txt": "p"})
+	fs := newTestServer(dir)
+	fs.acl.Store(&accessList{
+		users: map[string]string{"alice": "secret"},
+		rules: []aclRule{{pattern: "/private", users: []string{"alice"}}},
+	})
+	var logged bytes.Buffer
+	handler := withAccessLog(fs, noopEnricher{}, false, false, slog.New(slog.NewJSONHandler(&logged, nil)))
+
+	for _, tc := range []struct {
+		name, target, user, password string
+		status                       int
+		want                         string
+	}{
+		{"verified", "/private/a.txt", "alice", "secret", http.StatusOK, "alice"},
+		{"wrong password", "/private/a.txt", "alice", "guess", http.StatusUnauthorized, ""},
+		{"unknown user", "/public.txt", "mallory", "x", http.StatusOK, ""},
+	} {
+		logged.Reset()
+		r := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		r.SetBasicAuth(tc.user, tc.password)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.status)
+		}
+		var record struct{ User string }
+		if err := json.Unmarshal(logged.Bytes(), &record); err != nil {
+			t.Fatalf("%s: %v in %q", tc.name, err, logged.String())
+		}
+		if record.User != tc.want {
+			t.Errorf("%s: logged user %q, want %q", tc.name, record.User, tc.want)
+		}
+	}
+}
+
+func TestAccessLogFormSessionUser(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"private/a.txt": "a"})
+	fs := newTestServer(dir)
+	fs.formAuth = true
+	fs.sessionSecret = []byte("key")
+	acl := &accessList{
+		users: map[string]string{"bob": "pw"},
+		rules: []aclRule{{pattern: "/private", users: []string{"bob"}}},
+	}
+	fs.acl.Store(acl)
+	var logged bytes.Buffer
+	handler := withAccessLog(fs, noopEnricher{}, false, false, slog.New(slog.NewJSONHandler(&logged, nil)))
+
+	login := doRequest(handler, http.MethodPost, "/login", bytes.NewBufferString("username=bob&password=pw"),
+		"Content-Type", "application/x-www-form-urlencoded")
+	cookies := login.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatalf("login: status %d, no session cookie", login.Code)
+	}
+	var record struct{ User string }
+	if json.Unmarshal(logged.Bytes(), &record); record.User != "bob" {
+		t.Errorf("login logged user %q, want bob", record.User)
+	}
+
+	logged.Reset()
+	r := httptest.NewRequest(http.MethodGet, "/private/a.txt", nil)
+	r.AddCookie(cookies[0])
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	record.User = ""
+	json.Unmarshal(logged.Bytes(), &record)
+	if w.Code != http.StatusOK || record.User != "bob" {
+		t.Errorf("session request: status %d, logged user %q; want 200 and bob", w.Code, record.User)
+	}
+}
+
+// stubEnricher tags every request with a fixed country and ASN, and keeps
+// the address it was asked about.
+type stubEnricher struct{ asked net.IP }
+
+func (e *stubEnricher) Enrich(ip net.IP) map[string]string {
+	e.asked = ip
+	return map[string]string{"country": "NL", "asn": "AS64496"}
+}
+
+func TestAccessLogEnricherFields(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a"})
+	enricher := &stubEnricher{}
+	var logged bytes.Buffer
+	handler := withAccessLog(newTestServer(dir), enricher, false, false, slog.New(slog.NewJSONHandler(&logged, nil)))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a.txt", nil))
+
+	var record map[string]any
+	if err := json.Unmarshal(logged.Bytes(), &record); err != nil {
+		t.Fatalf("%v in %q", err, logged.String())
+	}
+	if record["country"] != "NL" || record["asn"] != "AS64496" {
+		t.Errorf("enricher fields missing from %q", logged.String())
+	}
+	if record["status"] != float64(http.StatusOK) || record["path"] != "/a.txt" {
+		t.Errorf("standard fields missing from %q", logged.String())
+	}
+	if enricher.asked.String() != "192.0.2.1" {
+		t.Errorf("enricher asked about %v, want the client address", enricher.asked)
+	}
+
+	// The logfmt line carries them too
+	var line bytes.Buffer
+	accessLogger.SetOutput(&line)
+	t.Cleanup(func() { accessLogger.SetOutput(os.Stderr) })
+	withAccessLog(newTestServer(dir), enricher, false, false, nil).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a.txt", nil))
+	if !strings.Contains(line.String(), " asn=AS64496 country=NL") {
+		t.Errorf("enricher fields missing from %q", line.String())
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// aclFile is the JSON layout of an --acl-file:
+//
+//	{
+//	  "users": {"alice": "$2a$10$...", "bob": "plain password"},
+//	  "rules": [
+//	    {"path": "/private", "users": ["alice"]},
+//	    {"path": "/lan/*.iso", "users": ["alice", "bob"], "ips": ["192.168.0.0/16"]}
+//	  ]
+//	}
+//
+// Passwords are bcrypt hashes or, failing the $2 prefix, plain text.
+type aclFile struct {
+	Users map[string]string `json:"users"`
+	Rules []struct {
+		Path  string   `json:"path"`
+		Users []string `json:"users"`
+		IPs   []string `json:"ips"`
+	} `json:"rules"`
+}
+
+// accessList is a parsed --acl-file.
+type accessList struct {
+	users map[string]string
+	rules []aclRule
+}
+
+// aclRule limits the paths matching pattern (a --hide style glob, with
+// everything below a matching directory included) to the listed users and
+// networks.
+type aclRule struct {
+	pattern string
+	users   []string
+	nets    []*net.IPNet
+}
+
+// loadACL reads and validates the --acl-file at filename.
+func loadACL(filename string) (*accessList, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var file aclFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	acl := &accessList{users: file.Users}
+	for i, rule := range file.Rules {
+		patterns, err := parseHidePatterns([]string{rule.Path})
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		for _, user := range rule.Users {
+			if _, ok := file.Users[user]; !ok {
+				return nil, fmt.Errorf("rule %d: unknown user %q", i+1, user)
+			}
+		}
+		nets, err := parseCIDRs(rule.IPs)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		acl.rules = append(acl.rules, aclRule{pattern: patterns[0], users: rule.Users, nets: nets})
+	}
+	return acl, nil
+}
+
+// check applies the first rule matching rel, a root-relative path as
+// returned by rootRelative. The request passes if it comes from one of the
+// rule's networks or user, the authenticated user of r ("" for none), is
+// one of the rule's users. Paths no rule matches are open.
+func (acl *accessList) check(r *http.Request, rel string, trustProxy bool, user string) error {
+	for _, rule := range acl.rules {
+		if !matchesPattern(rule.pattern, rel) {
+			continue
+		}
+		if ip := clientIP(r, trustProxy); ip != nil && containsIP(rule.nets, ip) {
+			return nil
+		}
+		if len(rule.users) == 0 {
+			return &requestError{http.StatusForbidden, "Forbidden"}
+		}
+		if user != "" {
+			for _, allowed := range rule.users {
+				if user == allowed {
+					return nil
+				}
+			}
+			return &requestError{http.StatusForbidden, "Forbidden"}
+		}
+		return &requestError{http.StatusUnauthorized, "Unauthorized"}
+	}
+	return nil
+}
+
+func (acl *accessList) validPassword(user, password string) bool {
+	stored, ok := acl.users[user]
+	if !ok {
+		return false
+	}
+	if strings.HasPrefix(stored, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// aclAllowed checks the --acl-file rules for absPath. Without an ACL
+// everything passes.
+func (fs *FileServer) aclAllowed(r *http.Request, absPath string) error {
+	acl := fs.acl.Load()
+	if acl == nil {
+		return nil
+	}
+	return acl.check(r, fs.rootRelative(absPath), fs.trustProxy, fs.requestUser(r, acl))
+}
+
+// aclReloadDelay lets a burst of change events settle, such as the
+// truncate and write of one save, before the --acl-file is read again.
+const aclReloadDelay = 200 * time.Millisecond
+
+// watchACL reloads the --acl-file into current whenever it changes. The
+// directory is watched rather than the file, so editors that save by
+// replacing the file are noticed too. A file that fails to load is logged
+// and the previous rules stay in force.
+func watchACL(filename string, current *atomic.Pointer[accessList]) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(filename)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		var reload *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filename || !event.Has(fsnotify.Write|fsnotify.Create) {
+					continue
+				}
+				if reload == nil {
+					reload = time.AfterFunc(aclReloadDelay, func() {
+						acl, err := loadACL(filename)
+						if err != nil {
+							log.Printf("Error reloading --acl-file, keeping the previous rules: %v", err)
+							return
+						}
+						current.Store(acl)
+						log.Printf("Reloaded --acl-file %s (%d rules)", filename, len(acl.rules))
+					})
+				} else {
+					reload.Reset(aclReloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Error watching --acl-file: %v", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeACL writes an --acl-file with the given users and rules JSON.
+func writeACL(t *testing.T, path, users, rules string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(`{"users": %s, "rules": %s}`, users, rules)), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// basicAuth returns the header pair for doRequest that logs in as user.
+func basicAuth(user, password string) []string {
+	return []string{"Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))}
+}
+
+func TestACLRules(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"private/a.txt": "a", "private/sub/b.txt": "b", "lan/disk.iso": "iso", "lan/notes.txt": "n", "public.txt": "p",
+	})
+	hash, err := bcrypt.GenerateFromPassword([]byte("hashed-pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aclPath := filepath.Join(t.TempDir(), "acl.json")
+	writeACL(t, aclPath, fmt.Sprintf(`{"alice": "plain-pw", "bob": %q}`, hash), `[
+		{"path": "/private", "users": ["alice"]},
+		{"path": "/lan/*.iso", "users": ["bob"], "ips": ["192.0.2.0/24"]},
+		{"path": "/lan", "users": ["bob"]}
+	]`)
+	acl, err := loadACL(aclPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := newTestServer(dir)
+	fs.acl.Store(acl)
+
+	alice := basicAuth("alice", "plain-pw")
+	for _, tc := range []struct {
+		target string
+		header []string
+		status int
+	}{
+		{"/public.txt", nil, http.StatusOK},
+		{"/private/a.txt", nil, http.StatusUnauthorized},
+		{"/private/a.txt", alice, http.StatusOK},
+		{"/private/sub/b.txt", nil, http.StatusUnauthorized}, // everything below a matching folder
+		{"/private/", nil, http.StatusUnauthorized},          // the listing too
+		{"/private/./a.txt", nil, http.StatusUnauthorized},   // other spellings of the path
+		{"/private//sub/b.txt", nil, http.StatusUnauthorized},
+		{"/private/a.txt", basicAuth("alice", "wrong"), http.StatusUnauthorized},
+		{"/lan/notes.txt", alice, http.StatusForbidden}, // authenticated, but not listed
+		{"/lan/disk.iso", nil, http.StatusOK},           // the client's network is allowed
+		{"/lan/notes.txt", nil, http.StatusUnauthorized},
+	} {
+		if w := doRequest(fs, http.MethodGet, tc.target, nil, tc.header...); w.Code != tc.status {
+			t.Errorf("GET %s %v: status %d, want %d", tc.target, tc.header, w.Code, tc.status)
+		}
+	}
+
+	w := doRequest(fs, http.MethodGet, "/lan/notes.txt", nil, basicAuth("bob", "hashed-pw")...)
+	if w.Code != http.StatusOK {
+		t.Errorf("bcrypt password: status %d, want 200", w.Code)
+	}
+}
+
+func TestLoadACLRejectsBadFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	for name, rules := range map[string]string{
+		"unknown user": `[{"path": "/x", "users": ["mallory"]}]`,
+		"bad network":  `[{"path": "/x", "ips": ["300.1.2.3/8"]}]`,
+		"not json":     `[{"path": `,
+	} {
+		writeACL(t, path, `{"alice": "pw"}`, rules)
+		if _, err := loadACL(path); err == nil {
+			t.Errorf("%s: loaded without an error", name)
+		}
+	}
+}
+
+func TestACLReloadKeepsLastGoodRules(t *testing.T) {
+	captureLog(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "b.txt": "b"})
+	aclPath := filepath.Join(t.TempDir(), "acl.json")
+	writeACL(t, aclPath, `{"alice": "pw"}`, `[{"path": "/a.txt", "users": ["alice"]}]`)
+	acl, err := loadACL(aclPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := newTestServer(dir)
+	fs.acl.Store(acl)
+	if err := watchACL(aclPath, &fs.acl); err != nil {
+		t.Fatal(err)
+	}
+
+	status := func(target string) int { return doRequest(fs, http.MethodGet, target, nil).Code }
+	waitFor := func(what string, done func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(20 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+
+	writeACL(t, aclPath, `{"alice": "pw"}`, `[{"path": "/b.txt", "users": ["alice"]}]`)
+	waitFor("the new rules", func() bool { return status("/b.txt") == http.StatusUnauthorized })
+	if got := status("/a.txt"); got != http.StatusOK {
+		t.Errorf("/a.txt after the reload: status %d, want 200", got)
+	}
+
+	current := fs.acl.Load()
+	writeACL(t, aclPath, `{"alice": "pw"}`, `[{"path": `)
+	time.Sleep(3 * aclReloadDelay)
+	if fs.acl.Load() != current || status("/b.txt") != http.StatusUnauthorized {
+		t.Error("a broken --acl-file replaced the rules in force")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// maxAutoPortTries bounds how far --auto-port walks from --port.
+const maxAutoPortTries = 100
+
+// listen opens the TCP listener on host:port. With autoPort, ports that are
+// in use are skipped in favour of the next ones.
+func listen(host string, port int, autoPort bool) (net.Listener, error) {
+	for try := 0; ; try++ {
+		ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port+try)))
+		if err == nil || !autoPort || !isAddrInUse(err) || try >= maxAutoPortTries || port == 0 || port+try >= 65535 {
+			return ln, err
+		}
+	}
+}
+
+// sdListenFDsStart is the first file descriptor systemd passes to a
+// socket-activated service.
+const sdListenFDsStart = 3
+
+// inheritedListener returns a listener on a socket handed over by the
+// parent process: descriptor fd when it is not negative, otherwise the
+// first socket of systemd socket activation (LISTEN_FDS, for this process
+// per LISTEN_PID). It returns nil when there is neither.
+func inheritedListener(fd int) (net.Listener, error) {
+	if fd < 0 {
+		count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || count < 1 {
+			return nil, nil
+		}
+		if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+			return nil, nil
+		}
+		// Like sd_listen_fds, don't pass the sockets on to child processes
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDNAMES")
+		fd = sdListenFDsStart
+	}
+
+	file := os.NewFile(uintptr(fd), "listen-fd-"+strconv.Itoa(fd))
+	if file == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	defer file.Close()
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("file descriptor %d: %w", fd, err)
+	}
+	return ln, nil
+}
+
+// isAddrInUse reports whether err is the "address already in use" bind error.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+// isUnspecifiedHost reports whether host binds every interface.
+func isUnspecifiedHost(host string) bool {
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// reachableURLs lists the URLs a client can use to reach a server bound to
+// host:port. For a wildcard bind that is localhost plus every non-loopback
+// interface address, so the server can be opened from another device.
+func reachableURLs(scheme, host string, port int) []string {
+	portStr := strconv.Itoa(port)
+	if !isUnspecifiedHost(host) {
+		return []string{scheme + "://" + net.JoinHostPort(host, portStr)}
+	}
+
+	urls := []string{scheme + "://" + net.JoinHostPort("localhost", portStr)}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return urls
+	}
+	ipv4Only := host != "" && net.ParseIP(host).To4() != nil
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipv4Only && ipNet.IP.To4() == nil {
+			continue
+		}
+		urls = append(urls, scheme+"://"+net.JoinHostPort(ipNet.IP.String(), portStr))
+	}
+	return urls
+}
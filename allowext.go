@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// parseAllowExts turns --allow-ext values into lowercase suffixes with
+// their dot, e.g. ".jpg" or ".tar.gz". Values may be comma-separated and
+// given with or without the leading dot.
+func parseAllowExts(values []string) ([]string, error) {
+	var exts []string
+	for _, value := range values {
+		for _, ext := range strings.Split(value, ",") {
+			ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+			if ext == "" || strings.ContainsAny(ext, `/\`) {
+				return nil, fmt.Errorf("invalid extension %q", value)
+			}
+			exts = append(exts, "."+ext)
+		}
+	}
+	return exts, nil
+}
+
+// extAllowed applies --allow-ext to the file at name: only the listed
+// extensions are served, compared case-insensitively, and extensionless
+// files (dotfiles like .env included) only with --allow-no-ext.
+// Directories always pass so the tree stays navigable.
+func (fs *FileServer) extAllowed(name string, isDir bool) bool {
+	if fs.allowExts == nil || isDir {
+		return true
+	}
+	base := strings.ToLower(strings.TrimLeft(filepath.Base(name), "."))
+	if !strings.Contains(base, ".") {
+		return fs.allowNoExt
+	}
+	for _, ext := range fs.allowExts {
+		if strings.HasSuffix(base, ext) {
+			return true
+		}
+	}
+	return false
+}
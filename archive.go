@@ -0,0 +1,240 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// isArchivePath reports whether --folder names an archive to serve as a
+// directory tree rather than a single file.
+func isArchivePath(name string) bool {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveTree is the read-only tree of a .zip or .tar(.gz) served in place
+// of a folder. Paths are slash-separated and relative, "" being the root.
+// Tar archives can't be read at random, so their files are kept in memory;
+// zip entries are read from the archive when requested.
+type archiveTree struct {
+	nodes map[string]*archiveNode
+	file  *os.File // the open .zip, nil for tar
+}
+
+// archiveNode is one file or directory of an archiveTree. It serves as both
+// the os.FileInfo and the os.DirEntry of the entry.
+type archiveNode struct {
+	name     string
+	size     int64
+	mode     os.FileMode
+	modTime  time.Time
+	children []string // names, sorted
+
+	zipFile *zip.File // zip entries
+	data    []byte    // tar entries
+}
+
+func (n *archiveNode) Name() string               { return n.name }
+func (n *archiveNode) Size() int64                { return n.size }
+func (n *archiveNode) Mode() os.FileMode          { return n.mode }
+func (n *archiveNode) ModTime() time.Time         { return n.modTime }
+func (n *archiveNode) IsDir() bool                { return n.mode.IsDir() }
+func (n *archiveNode) Sys() any                   { return nil }
+func (n *archiveNode) Type() os.FileMode          { return n.mode.Type() }
+func (n *archiveNode) Info() (os.FileInfo, error) { return n, nil }
+
+// openArchive reads the index of the archive at name, and for tar archives
+// their contents too.
+func openArchive(name string) (*archiveTree, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	tree := &archiveTree{nodes: map[string]*archiveNode{
+		"": {mode: os.ModeDir | 0555, modTime: info.ModTime()},
+	}}
+
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, ".zip") {
+		reader, err := zip.NewReader(file, info.Size())
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		for _, f := range reader.File {
+			node := &archiveNode{size: int64(f.UncompressedSize64), mode: f.Mode(), modTime: f.Modified, zipFile: f}
+			tree.add(f.Name, node)
+		}
+		tree.file = file
+	} else {
+		defer file.Close()
+		var src io.Reader = file
+		if !strings.HasSuffix(lower, ".tar") {
+			gz, err := gzip.NewReader(file)
+			if err != nil {
+				return nil, err
+			}
+			defer gz.Close()
+			src = gz
+		}
+		if err := tree.readTar(tar.NewReader(src)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, node := range tree.nodes {
+		sort.Strings(node.children)
+	}
+	return tree, nil
+}
+
+// readTar adds the directories and regular files of a tar stream.
+func (t *archiveTree) readTar(tr *tar.Reader) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		node := &archiveNode{size: header.Size, mode: header.FileInfo().Mode(), modTime: header.ModTime}
+		switch header.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeReg:
+			if node.data, err = io.ReadAll(tr); err != nil {
+				return err
+			}
+		default:
+			continue // links and devices have nothing to serve
+		}
+		t.add(header.Name, node)
+	}
+}
+
+// add files node under name, creating the directories above it. Names that
+// would climb out of the archive root are dropped.
+func (t *archiveTree) add(name string, node *archiveNode) {
+	name = strings.Trim(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+	if name == "" || name == "." || strings.HasPrefix(name, "../") {
+		return
+	}
+	if !node.IsDir() && !node.mode.IsRegular() {
+		return
+	}
+	node.name = path.Base(name)
+	if existing, ok := t.nodes[name]; ok {
+		if existing.IsDir() && node.IsDir() {
+			existing.modTime = node.modTime
+			return
+		}
+		node.children = existing.children
+	} else {
+		parent := t.ensureDir(path.Dir(name))
+		parent.children = append(parent.children, node.name)
+	}
+	t.nodes[name] = node
+}
+
+// ensureDir returns the directory node for name, creating it and its
+// parents if the archive has no explicit entries for them.
+func (t *archiveTree) ensureDir(name string) *archiveNode {
+	if name == "." {
+		name = ""
+	}
+	if node, ok := t.nodes[name]; ok {
+		return node
+	}
+	node := &archiveNode{name: path.Base(name), mode: os.ModeDir | 0555, modTime: t.nodes[""].modTime}
+	parent := t.ensureDir(path.Dir(name))
+	parent.children = append(parent.children, node.name)
+	t.nodes[name] = node
+	return node
+}
+
+// stat looks up the root-relative path rel ("/" for the root).
+func (t *archiveTree) stat(rel string) (*archiveNode, error) {
+	if node, ok := t.nodes[strings.Trim(rel, "/")]; ok {
+		return node, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// readDir lists the directory at rel.
+func (t *archiveTree) readDir(rel string) ([]*archiveNode, error) {
+	dir, err := t.stat(rel)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.IsDir() {
+		return nil, errors.New("not a directory")
+	}
+	prefix := strings.Trim(rel, "/")
+	entries := make([]*archiveNode, 0, len(dir.children))
+	for _, child := range dir.children {
+		entries = append(entries, t.nodes[strings.TrimPrefix(prefix+"/"+child, "/")])
+	}
+	return entries, nil
+}
+
+// open returns the contents of the file at rel. Stored zip entries are
+// read in place, so ranges are cheap; compressed ones have to be inflated
+// into memory first.
+func (t *archiveTree) open(rel string) (io.ReadSeeker, os.FileInfo, error) {
+	node, err := t.stat(rel)
+	if err != nil {
+		return nil, nil, err
+	}
+	if node.IsDir() {
+		return nil, nil, errors.New("is a directory")
+	}
+	if node.zipFile == nil {
+		return bytes.NewReader(node.data), node, nil
+	}
+	if node.zipFile.Method == zip.Store {
+		offset, err := node.zipFile.DataOffset()
+		if err != nil {
+			return nil, nil, err
+		}
+		return io.NewSectionReader(t.file, offset, node.size), node, nil
+	}
+	entry, err := node.zipFile.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer entry.Close()
+	data, err := io.ReadAll(entry)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s from archive: %w", rel, err)
+	}
+	return bytes.NewReader(data), node, nil
+}
+
+// stat is os.Stat for request paths, looking inside the archive when
+// serving one.
+func (fs *FileServer) stat(absPath string) (os.FileInfo, error) {
+	if fs.archive != nil {
+		return fs.archive.stat(fs.rootRelative(absPath))
+	}
+	return os.Stat(absPath)
+}
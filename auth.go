@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// loginPath and logoutPath are reserved below the root (and any
+	// --base-url) with --auth-mode=form.
+	loginPath  = "/login"
+	logoutPath = "/logout"
+
+	sessionCookie = "shs_session"
+
+	// sessionLifetime is how long a form login lasts.
+	sessionLifetime = 24 * time.Hour
+)
+
+// newSessionSecret returns the --auth-secret key, or a random one when
+// none is given, in which case sessions end with the process.
+func newSessionSecret(secret string) ([]byte, error) {
+	if secret != "" {
+		return []byte(secret), nil
+	}
+	key := make([]byte, 32)
+	if _, err := crand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// sessionMAC signs a session for user until expires. The user's stored
+// password goes into the signature, so changing it in the --acl-file ends
+// their sessions.
+func (fs *FileServer) sessionMAC(user string, expires int64, stored string) string {
+	mac := hmac.New(sha256.New, fs.sessionSecret)
+	fmt.Fprintf(mac, "%s\x00%d\x00%s", user, expires, stored)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sessionUser returns the user of a valid session cookie on r, or "".
+func (fs *FileServer) sessionUser(r *http.Request, acl *accessList) string {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	name, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ""
+	}
+	user := string(name)
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return ""
+	}
+	stored, ok := acl.users[user]
+	if !ok || !hmac.Equal([]byte(parts[2]), []byte(fs.sessionMAC(user, expires, stored))) {
+		return ""
+	}
+	return user
+}
+
+// requestUser returns the authenticated user of r: the session with
+// --auth-mode=form, else (and for API clients in form mode) basic auth.
+// A user found is recorded for the access log with setAuthUser.
+func (fs *FileServer) requestUser(r *http.Request, acl *accessList) string {
+	if fs.formAuth {
+		if user := fs.sessionUser(r, acl); user != "" {
+			setAuthUser(r, user)
+			return user
+		}
+	}
+	if user, password, ok := r.BasicAuth(); ok && acl.validPassword(user, password) {
+		setAuthUser(r, user)
+		return user
+	}
+	return ""
+}
+
+type authUserKey struct{}
+
+// withAuthUserSlot returns r with room in its context for the verified
+// user, which the handlers below fill in and the caller reads back with
+// authUser once they are done.
+func withAuthUserSlot(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authUserKey{}, new(string)))
+}
+
+// setAuthUser records user as having passed authentication for r.
+func setAuthUser(r *http.Request, user string) {
+	if slot, ok := r.Context().Value(authUserKey{}).(*string); ok {
+		*slot = user
+	}
+}
+
+// authUser returns the user recorded by setAuthUser, or "" when r wasn't
+// authenticated. Names from an Authorization header that weren't checked,
+// or failed the check, never show up here.
+func authUser(r *http.Request) string {
+	if slot, ok := r.Context().Value(authUserKey{}).(*string); ok {
+		return *slot
+	}
+	return ""
+}
+
+// sessionCookiePath scopes the cookie to the served tree.
+func (fs *FileServer) sessionCookiePath() string {
+	return fs.baseURL + "/"
+}
+
+// wantsLoginPage reports whether a 401 for r should send a browser to the
+// login form instead.
+func (fs *FileServer) wantsLoginPage(r *http.Request) bool {
+	return fs.formAuth && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// redirectToLogin sends the browser to the login form, returning to the
+// requested page afterwards.
+func (fs *FileServer) redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	target := fs.baseURL + loginPath + "?next=" + url.QueryEscape(r.URL.RequestURI())
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// safeNext returns the page to go to after logging in: a local path, or
+// the root for anything else, so the form can't bounce users off-site.
+func (fs *FileServer) safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.Contains(next, "\\") {
+		return fs.baseURL + "/"
+	}
+	return next
+}
+
+const loginCSS = `
+` + pageCSS + `
+        form { max-width: 300px; }
+        label { display: block; margin: 10px 0 4px; }
+        input[type=text], input[type=password] { width: 100%; padding: 6px; box-sizing: border-box; }
+        input[type=submit] { margin-top: 15px; padding: 6px 16px; }
+        .error { color: #c00; }
+    `
+
+var loginCSP = pageCSP(loginCSS, "")
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Log in</title>
+    <style>` + loginCSS + `</style>
+</head>
+<body>
+    <h1>Log in</h1>
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    <form method="post" action="{{.Action}}">
+        <input type="hidden" name="next" value="{{.Next}}">
+        <label for="username">Username</label>
+        <input type="text" id="username" name="username" value="{{.Username}}" autocomplete="username" autofocus required>
+        <label for="password">Password</label>
+        <input type="password" id="password" name="password" autocomplete="current-password" required>
+        <input type="submit" value="Log in">
+    </form>
+</body>
+</html>`))
+
+// serveLogin shows the login form on GET and checks it on POST, setting
+// the session cookie and redirecting to ?next= on success.
+func (fs *FileServer) serveLogin(w http.ResponseWriter, r *http.Request) {
+	page := struct {
+		Action, Next, Username, Error string
+	}{Action: fs.baseURL + loginPath, Next: fs.safeNext(r.URL.Query().Get("next"))}
+
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+		page.Next = fs.safeNext(r.PostFormValue("next"))
+		page.Username = r.PostFormValue("username")
+		acl := fs.acl.Load()
+		if acl.validPassword(page.Username, r.PostFormValue("password")) {
+			setAuthUser(r, page.Username)
+			expires := time.Now().Add(sessionLifetime).Unix()
+			value := base64.RawURLEncoding.EncodeToString([]byte(page.Username)) + "." +
+				strconv.FormatInt(expires, 10) + "." + fs.sessionMAC(page.Username, expires, acl.users[page.Username])
+			http.SetCookie(w, &http.Cookie{
+				Name:     sessionCookie,
+				Value:    value,
+				Path:     fs.sessionCookiePath(),
+				Expires:  time.Unix(expires, 0),
+				HttpOnly: true,
+				Secure:   requestScheme(r, fs.trustProxy) == "https",
+				SameSite: http.SameSiteLaxMode,
+			})
+			http.Redirect(w, r, page.Next, http.StatusSeeOther)
+			return
+		}
+		log.Printf("Failed login for %q from %s", page.Username, clientIP(r, fs.trustProxy))
+		page.Error = "Wrong username or password."
+		status = http.StatusUnauthorized
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		fs.serveError(w, r, http.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", loginCSP)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		if err := loginTemplate.Execute(w, page); err != nil {
+			log.Printf("Error rendering login page: %v", err)
+		}
+	}
+}
+
+// serveLogout clears the session cookie and returns to the login form.
+func (fs *FileServer) serveLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     fs.sessionCookiePath(),
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, fs.baseURL+loginPath, http.StatusSeeOther)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newFormAuthServer(t *testing.T) *FileServer {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"private/a.txt": "a", "public.txt": "p"})
+	fs := newTestServer(dir)
+	fs.formAuth = true
+	fs.sessionSecret = []byte("key")
+	fs.acl.Store(&accessList{
+		users: map[string]string{"alice": "pw", "bob": "pw2"},
+		rules: []aclRule{{pattern: "/private", users: []string{"alice"}}},
+	})
+	return fs
+}
+
+// login posts the login form and returns the response.
+func login(fs *FileServer, user, password, next string) *http.Response {
+	form := url.Values{"username": {user}, "password": {password}, "next": {next}}
+	return doRequest(fs, http.MethodPost, loginPath, strings.NewReader(form.Encode()),
+		"Content-Type", "application/x-www-form-urlencoded").Result()
+}
+
+func TestFormLogin(t *testing.T) {
+	captureLog(t)
+	fs := newFormAuthServer(t)
+
+	// Browsers are sent to the form, API clients get a plain 401
+	w := doRequest(fs, http.MethodGet, "/private/a.txt", nil, "Accept", "text/html")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login?next=%2Fprivate%2Fa.txt" {
+		t.Errorf("browser: status %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := doRequest(fs, http.MethodGet, "/private/a.txt", nil, "Accept", "application/json"); w.Code != http.StatusUnauthorized {
+		t.Errorf("API client: status %d, want 401", w.Code)
+	}
+
+	if resp := login(fs, "alice", "wrong", "/private/a.txt"); resp.StatusCode != http.StatusUnauthorized || len(resp.Cookies()) != 0 {
+		t.Errorf("wrong password: status %d, %d cookies", resp.StatusCode, len(resp.Cookies()))
+	}
+	resp := login(fs, "alice", "pw", "/private/a.txt")
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/private/a.txt" || len(resp.Cookies()) != 1 {
+		t.Fatalf("login: status %d, Location %q, %d cookies", resp.StatusCode, resp.Header.Get("Location"), len(resp.Cookies()))
+	}
+	session := resp.Cookies()[0]
+	if !session.HttpOnly || session.SameSite != http.SameSiteLaxMode {
+		t.Errorf("session cookie %+v isn't HttpOnly and SameSite=Lax", session)
+	}
+	withSession := []string{"Cookie", session.Name + "=" + session.Value}
+	if w := doRequest(fs, http.MethodGet, "/private/a.txt", nil, withSession...); w.Code != http.StatusOK {
+		t.Errorf("with the session: status %d, want 200", w.Code)
+	}
+	// Basic auth still works for API clients in form mode
+	if w := doRequest(fs, http.MethodGet, "/private/a.txt", nil, basicAuth("alice", "pw")...); w.Code != http.StatusOK {
+		t.Errorf("basic auth in form mode: status %d, want 200", w.Code)
+	}
+
+	logout := doRequest(fs, http.MethodGet, logoutPath, nil, withSession...)
+	if cookies := logout.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("logout didn't clear the cookie: %v", cookies)
+	}
+}
+
+func TestForgedSessionsAreRejected(t *testing.T) {
+	fs := newFormAuthServer(t)
+	session := login(fs, "bob", "pw2", "/").Cookies()[0]
+	parts := strings.Split(session.Value, ".")
+
+	other := newFormAuthServer(t)
+	other.sessionSecret = []byte("other key")
+	foreign := login(other, "alice", "pw", "/").Cookies()[0]
+
+	// The genuine session is recognized, but bob isn't on the rule
+	if w := doRequest(fs, http.MethodGet, "/private/a.txt", nil, "Cookie", session.Name+"="+session.Value); w.Code != http.StatusForbidden {
+		t.Fatalf("bob's session: status %d, want 403", w.Code)
+	}
+	for name, value := range map[string]string{
+		"bob's session renamed to alice": "YWxpY2U." + parts[1] + "." + parts[2],
+		"expiry pushed back":             parts[0] + ".9999999999." + parts[2],
+		"signed with another key":        foreign.Value,
+		"garbage":                        "not-a-session",
+	} {
+		if w := doRequest(fs, http.MethodGet, "/private/a.txt", nil, "Cookie", session.Name+"="+value); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", name, w.Code)
+		}
+	}
+}
+
+func TestLoginRedirectStaysLocal(t *testing.T) {
+	fs := newFormAuthServer(t)
+	for next, want := range map[string]string{
+		"/private/a.txt":       "/private/a.txt",
+		"https://evil.example": "/",
+		"//evil.example/":      "/",
+		`/\evil.example`:       "/",
+		"":                     "/",
+	} {
+		if got := login(fs, "alice", "pw", next).Header.Get("Location"); got != want {
+			t.Errorf("next=%q: redirected to %q, want %q", next, got, want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// parseDomains splits a comma-separated --autocert-domain value.
+func parseDomains(value string) ([]string, error) {
+	var domains []string
+	for _, domain := range strings.Split(value, ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+		if strings.ContainsAny(domain, "/:* ") {
+			return nil, fmt.Errorf("invalid domain %q", domain)
+		}
+		domains = append(domains, domain)
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("no domain given")
+	}
+	return domains, nil
+}
+
+// newCertManager returns an autocert.Manager that obtains and renews Let's
+// Encrypt certificates for domains only, keeping them in cacheDir (a
+// directory under the user cache dir if empty) across restarts.
+func newCertManager(domains []string, cacheDir string) *autocert.Manager {
+	if cacheDir == "" {
+		cacheDir = "autocert-cache"
+		if dir, err := os.UserCacheDir(); err == nil {
+			cacheDir = filepath.Join(dir, "simple-http-server", "autocert")
+		}
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+	}
+}
+
+// serveACMEChallenges answers HTTP-01 challenges on port 80 of host and
+// redirects every other plain HTTP request to HTTPS.
+func serveACMEChallenges(manager *autocert.Manager, host string) {
+	server := &http.Server{
+		Addr:              net.JoinHostPort(host, "80"),
+		Handler:           manager.HTTPHandler(nil),
+		ReadHeaderTimeout: *headerTimeout,
+		IdleTimeout:       *idleTimeout,
+	}
+	log.Fatal(server.ListenAndServe())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// maxBundleFiles bounds how many files a single /.bundle request may join.
+const maxBundleFiles = 20
+
+// serveBundle streams the files listed in ?files= (comma-separated, relative
+// to the serve root) concatenated in the given order. All files must share
+// a MIME type, which becomes the response's Content-Type. Every path is
+// resolved and access-checked before anything is written.
+func (fs *FileServer) serveBundle(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	for _, name := range strings.Split(r.URL.Query().Get("files"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, strings.TrimPrefix(name, "/"))
+		}
+	}
+	if len(names) == 0 {
+		fs.serveError(w, r, http.StatusBadRequest, "Bad Request: files parameter is required")
+		return
+	}
+	if len(names) > maxBundleFiles {
+		fs.serveError(w, r, http.StatusBadRequest, fmt.Sprintf("Bad Request: at most %d files per bundle", maxBundleFiles))
+		return
+	}
+
+	mimeType := getMimeType(names[0])
+	files := make([]openedFile, 0, len(names))
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, name := range names {
+		if getMimeType(name) != mimeType {
+			fs.serveError(w, r, http.StatusBadRequest, "Bad Request: bundled files must share a content type")
+			return
+		}
+		absPath, err := fs.resolvePath(name)
+		if err == nil {
+			err = fs.checkAccess(r, absPath)
+		}
+		if err != nil {
+			fs.writeRequestError(w, r, err)
+			return
+		}
+		file, err := fs.openFile(absPath)
+		if isOutOfFiles(err) {
+			fs.serveReadError(w, r, err)
+			return
+		}
+		if err != nil {
+			fs.serveError(w, r, http.StatusNotFound, "Not Found: "+name)
+			return
+		}
+		files = append(files, file)
+		if info, err := file.Stat(); err != nil || !info.Mode().IsRegular() || !fs.extAllowed(absPath, false) {
+			fs.serveError(w, r, http.StatusNotFound, "Not Found: "+name)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	out := fs.throttle(w, r)
+	for i, file := range files {
+		if i > 0 {
+			io.WriteString(out, bundleSeparator(names[i]))
+		}
+		if _, err := fs.copy(out, file); err != nil {
+			log.Printf("Error writing bundle: %v", err)
+			return
+		}
+	}
+}
+
+// bundleSeparator returns what goes between two bundled files. Scripts get
+// a semicolon so a missing one at the end of a file can't merge statements,
+// and scripts and stylesheets get a comment naming the next file.
+func bundleSeparator(next string) string {
+	switch strings.ToLower(filepath.Ext(next)) {
+	case ".js":
+		return fmt.Sprintf("\n;/* %s */\n", next)
+	case ".css":
+		return fmt.Sprintf("\n/* %s */\n", next)
+	default:
+		return "\n"
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundleConcatenatesInOrder(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"js/a.js": "var a = 1", "js/b.js": "var b = 2;", "style.css": "p {}"})
+	fs := newTestServer(dir)
+	fs.bundle = true
+
+	w := doRequest(fs, http.MethodGet, "/.bundle?files=js/b.js,/js/a.js", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if want := "var b = 2;\n;/* js/a.js */\nvar a = 1"; w.Body.String() != want {
+		t.Errorf("body %q, want %q", w.Body, want)
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/javascript") && !strings.HasPrefix(got, "application/javascript") {
+		t.Errorf("Content-Type %q", got)
+	}
+}
+
+func TestBundleRejectsBadFileLists(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "root")
+	writeFiles(t, parent, map[string]string{"secret.js": "outside", "root/a.js": "a", "root/private/p.js": "p", "root/style.css": "p {}"})
+	fs := newTestServer(dir)
+	fs.bundle = true
+	fs.acl.Store(&accessList{
+		users: map[string]string{"alice": "pw"},
+		rules: []aclRule{{pattern: "/private", users: []string{"alice"}}},
+	})
+
+	for _, tc := range []struct {
+		files  string
+		status int
+	}{
+		{"", http.StatusBadRequest},
+		{"a.js,../secret.js", http.StatusForbidden},
+		{"a.js,private/p.js", http.StatusUnauthorized},
+		{"a.js,missing.js", http.StatusNotFound},
+		{"a.js,style.css", http.StatusBadRequest},
+		{strings.Repeat("a.js,", maxBundleFiles+1), http.StatusBadRequest},
+	} {
+		w := doRequest(fs, http.MethodGet, "/.bundle?files="+tc.files, nil)
+		if w.Code != tc.status {
+			t.Errorf("files=%.40s: status %d, want %d", tc.files, w.Code, tc.status)
+		}
+		if strings.Contains(w.Body.String(), "outside") || strings.Contains(w.Body.String(), "\np") {
+			t.Errorf("files=%.40s: leaked a refused file: %q", tc.files, w.Body)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// stateDir returns the default directory for the server's own files of
+// kind (partial tus uploads, thumbnails) for the tree at servePath: below
+// the user cache directory, one per served tree so instances serving
+// different folders don't share files. Without a cache directory it is a
+// fresh temporary directory that lasts for this run.
+func stateDir(kind, servePath string) (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return os.MkdirTemp("", "simple-http-server-"+kind+"-")
+	}
+	sum := sha256.Sum256([]byte(servePath))
+	return filepath.Join(base, "simple-http-server", kind, hex.EncodeToString(sum[:8])), nil
+}
+
+// privateDir creates dir with mode 0700 if needed and makes sure nobody but
+// the current user can get at what the server keeps there: it must be a
+// real directory rather than a symlink, owned by the current user, and
+// closed to group and others. Otherwise another local user could read or
+// replace its files.
+func privateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if err := checkOwner(info); err != nil {
+		return fmt.Errorf("%s: %v", dir, err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("%s is open to other users (mode %04o); chmod 700 it", dir, perm)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrivateDir(t *testing.T) {
+	base := t.TempDir()
+
+	created := filepath.Join(base, "new", "tus")
+	if err := privateDir(created); err != nil {
+		t.Fatalf("new directory: %v", err)
+	}
+	if info, err := os.Stat(created); err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("new directory has mode %v, want 0700", info.Mode().Perm())
+	}
+	if err := privateDir(created); err != nil {
+		t.Errorf("existing private directory: %v", err)
+	}
+
+	open := filepath.Join(base, "open")
+	if err := os.Mkdir(open, 0700); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(open, 0777)
+	if err := privateDir(open); err == nil {
+		t.Error("a world-writable directory was accepted")
+	}
+
+	link := filepath.Join(base, "link")
+	if err := os.Symlink(created, link); err != nil {
+		t.Skip("no symlinks:", err)
+	}
+	if err := privateDir(link); err == nil {
+		t.Error("a symlink was accepted")
+	}
+}
+
+func TestStateDirIsPerTree(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	a, errA := stateDir("thumbs", "/srv/a")
+	b, errB := stateDir("thumbs", "/srv/b")
+	if errA != nil || errB != nil {
+		t.Fatal(errA, errB)
+	}
+	if a == b {
+		t.Errorf("two trees share %s", a)
+	}
+	if again, _ := stateDir("thumbs", "/srv/a"); again != a {
+		t.Errorf("stateDir is not stable: %s, then %s", a, again)
+	}
+}
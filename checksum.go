@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCachedChecksums bounds the checksum cache; it is reset when full.
+const maxCachedChecksums = 10000
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+}
+
+// checksumKey identifies one version of a file for one algorithm.
+type checksumKey struct {
+	path      string
+	size      int64
+	modTime   time.Time
+	algorithm string
+}
+
+type cachedChecksum struct {
+	sum     string
+	created time.Time
+}
+
+// checksumCache remembers computed digests, so unchanged files are not
+// hashed again. Keys include size and ModTime, so edits miss the cache.
+type checksumCache struct {
+	mu      sync.Mutex
+	entries map[checksumKey]cachedChecksum
+}
+
+func (c *checksumCache) get(key checksumKey) (cachedChecksum, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+func (c *checksumCache) put(key checksumKey, sum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= maxCachedChecksums {
+		c.entries = make(map[checksumKey]cachedChecksum)
+	}
+	c.entries[key] = cachedChecksum{sum: sum, created: time.Now()}
+}
+
+// forget drops the digests of path and of anything below it.
+func (c *checksumCache) forget(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if isWithin(key.path, path) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// serveChecksum answers ?checksum=sha256 (or sha1, md5) with the digest of
+// the file instead of its contents: as JSON for API clients, otherwise as a
+// sha256sum-style text line.
+func (fs *FileServer) serveChecksum(w http.ResponseWriter, r *http.Request, filePath string) {
+	algorithm := strings.ToLower(r.URL.Query().Get("checksum"))
+	if algorithm == "" {
+		algorithm = "sha256"
+	}
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		fs.serveError(w, r, http.StatusBadRequest, "Bad Request: checksum must be sha256, sha1 or md5")
+		return
+	}
+
+	file, err := fs.openFile(filePath)
+	if err != nil {
+		fs.serveReadError(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error reading file: %v", err))
+		return
+	}
+
+	key := checksumKey{path: filePath, size: info.Size(), modTime: info.ModTime(), algorithm: algorithm}
+	entry, ok := fs.checksums.get(key)
+	if ok {
+		setAge(w, entry.created)
+	} else {
+		h := newHash()
+		if _, err := fs.copy(h, contextReader{r.Context(), file}); err != nil {
+			fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error reading file: %v", err))
+			return
+		}
+		entry.sum = hex.EncodeToString(h.Sum(nil))
+		fs.checksums.put(key, entry.sum)
+	}
+
+	filename := filepath.Base(filePath)
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"file":      filename,
+			"algorithm": algorithm,
+			"checksum":  entry.sum,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%s  %s\n", entry.sum, filename)
+}
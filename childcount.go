@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxChildCount bounds how many entries are read to count a subdirectory;
+// bigger directories are shown as "10000+".
+const maxChildCount = 10000
+
+// maxCachedChildCounts bounds the count cache; it is reset when full.
+const maxCachedChildCounts = 10000
+
+type childCountKey struct {
+	path    string
+	modTime time.Time
+}
+
+// childCountCache remembers subdirectory entry counts. Adding or removing
+// an entry changes the directory's ModTime, so stale counts miss the cache.
+type childCountCache struct {
+	mu      sync.Mutex
+	entries map[childCountKey]string
+}
+
+// count returns the number of entries in the directory at path, formatted
+// for the listing, or "" if it can't be read.
+func (c *childCountCache) count(path string, modTime time.Time) string {
+	key := childCountKey{path: path, modTime: modTime}
+
+	c.mu.Lock()
+	count, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok {
+		return count
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer dir.Close()
+
+	// Names are read in batches so a huge directory isn't held in memory
+	n := 0
+	for n < maxChildCount {
+		names, err := dir.Readdirnames(min(1000, maxChildCount-n))
+		n += len(names)
+		if err != nil {
+			break
+		}
+	}
+	count = strconv.Itoa(n)
+	if n >= maxChildCount {
+		count += "+"
+	}
+
+	c.mu.Lock()
+	if c.entries == nil || len(c.entries) >= maxCachedChildCounts {
+		c.entries = make(map[childCountKey]string)
+	}
+	c.entries[key] = count
+	c.mu.Unlock()
+	return count
+}
+
+// forget drops the counts of path and of the directories below it.
+func (c *childCountCache) forget(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if isWithin(key.path, path) {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// childCountCell returns the last cell of the listing row for name, the
+// child count column when it is the last one enabled.
+func childCountCell(t *testing.T, page, name string) string {
+	t.Helper()
+	start := strings.Index(page, `data-name="`+name+`"`)
+	if start < 0 {
+		t.Fatalf("no row for %s", name)
+	}
+	row := page[start:]
+	row = row[:strings.Index(row, "</tr>")]
+	cells := regexp.MustCompile(`<td>([^<]*)</td>`).FindAllStringSubmatch(row, -1)
+	return cells[len(cells)-1][1]
+}
+
+func TestChildCountsMatchEntries(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"photos/a.jpg": "", "photos/b.jpg": "", "photos/c.jpg": "", "photos/2024/d.jpg": "",
+		"readme.txt": "",
+	})
+	os.Mkdir(filepath.Join(dir, "empty"), 0755)
+	fs := newTestServer(dir)
+	fs.childCounts = true
+
+	page := doRequest(fs, http.MethodGet, "/", nil).Body.String()
+	for name, want := range map[string]string{"photos": "4", "empty": "0", "readme.txt": "-"} {
+		if got := childCountCell(t, page, name); got != want {
+			t.Errorf("%s: child count %q, want %q", name, got, want)
+		}
+	}
+
+	// Adding an entry changes the directory's mtime, so the cached count
+	// is dropped
+	writeFiles(t, dir, map[string]string{"empty/new.txt": ""})
+	page = doRequest(fs, http.MethodGet, "/", nil).Body.String()
+	if got := childCountCell(t, page, "empty"); got != "1" {
+		t.Errorf("after adding a file: child count %q, want 1", got)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// version and commit are set at build time:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD)"
+//
+// Without them commit falls back to the VCS revision Go embeds in builds
+// from a checkout.
+var (
+	version = "dev"
+	commit  = ""
+)
+
+// commands are the subcommands; the first argument picks one, and anything
+// else, flags included, is serve for compatibility with older invocations.
+var commands = map[string]func(args []string){
+	"serve":     serveCommand,
+	"version":   versionCommand,
+	"genconfig": genconfigCommand,
+}
+
+func init() {
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage:\n")
+		fmt.Fprintf(out, "  %s [serve] --folder DIR [flags]  serve files (the default)\n", os.Args[0])
+		fmt.Fprintf(out, "  %s version                       print the version and exit\n", os.Args[0])
+		fmt.Fprintf(out, "  %s genconfig                     print a commented --config file with every flag\n", os.Args[0])
+		fmt.Fprintf(out, "\nFlags of serve:\n")
+		flag.PrintDefaults()
+	}
+}
+
+// serveCommand parses the serve flags from args, then any --config file and
+// the environment defaults, and runs the server.
+func serveCommand(args []string) {
+	flag.CommandLine.Parse(args)
+	if *configFile != "" {
+		if err := applyConfigFile(*configFile); err != nil {
+			fatalf("Error: --config: %v", err)
+		}
+	}
+	applyEnvDefaults()
+	serve()
+}
+
+// versionCommand prints the build's version, commit and Go version.
+func versionCommand(args []string) {
+	if len(args) > 0 {
+		fatalf("Error: version takes no arguments")
+	}
+	revision := commit
+	if revision == "" {
+		revision = "unknown"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" {
+					revision = setting.Value
+				}
+			}
+		}
+	}
+	fmt.Printf("simple-http-server %s (commit %s, %s %s/%s)\n", version, revision, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// genconfigCommand prints the --config template to stdout.
+func genconfigCommand(args []string) {
+	if len(args) > 0 {
+		fatalf("Error: genconfig takes no arguments")
+	}
+	writeConfigTemplate(os.Stdout)
+}
+
+// notConfigurable are flags that make no sense in a --config file: the
+// file itself, and the ones that run a one-off action instead of serving.
+var notConfigurable = map[string]bool{
+	"config":       true,
+	"check":        true,
+	"sign-url":     true,
+	"sign-hotlink": true,
+}
+
+// writeConfigTemplate writes every configurable flag, commented out with
+// its description and default, in the format applyConfigFile reads.
+func writeConfigTemplate(w io.Writer) {
+	fmt.Fprintln(w, "# simple-http-server configuration, for --config FILE.")
+	fmt.Fprintln(w, "#")
+	fmt.Fprintln(w, "# One flag per line as name = value, without the leading dashes. Repeat")
+	fmt.Fprintln(w, "# a line for repeatable flags. Flags given on the command line win over")
+	fmt.Fprintln(w, "# this file, which wins over SHS_PORT/SHS_FOLDER. Uncomment a line and")
+	fmt.Fprintln(w, "# fill in a value to change a default; empty values are ignored.")
+	flag.VisitAll(func(f *flag.Flag) {
+		if notConfigurable[f.Name] {
+			return
+		}
+		fmt.Fprintf(w, "\n# %s\n", f.Usage)
+		fmt.Fprintf(w, "%s\n", strings.TrimSpace("#"+f.Name+" = "+f.DefValue))
+	})
+}
+
+// applyConfigFile sets the flags listed in the --config file at filename,
+// skipping those already given on the command line. Values are taken as
+// written up to the end of the line; a double-quoted value is unquoted,
+// for the odd value with leading or trailing spaces. An empty value leaves
+// the flag alone, so an uncommented template line changes nothing.
+func applyConfigFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: want name = value, got %q", filename, lineNo, line)
+		}
+		name = strings.TrimLeft(strings.TrimSpace(name), "-")
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if strings.HasPrefix(value, `"`) {
+			if value, err = strconv.Unquote(value); err != nil {
+				return fmt.Errorf("%s:%d: bad quoted value for %s: %v", filename, lineNo, name, err)
+			}
+		}
+		if flag.Lookup(name) == nil || notConfigurable[name] {
+			return fmt.Errorf("%s:%d: unknown flag %q", filename, lineNo, name)
+		}
+		if set[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: %s: %v", filename, lineNo, name, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/term"
+)
+
+// ANSI SGR codes used for the banner and access log.
+const (
+	ansiBold   = "1"
+	ansiDim    = "2"
+	ansiRed    = "31"
+	ansiGreen  = "32"
+	ansiYellow = "33"
+	ansiCyan   = "36"
+)
+
+// useColor decides from a --color mode whether output to f gets ANSI
+// colors. In auto mode that takes a terminal and no NO_COLOR variable
+// (https://no-color.org), so piped or redirected output stays plain.
+func useColor(mode string, f *os.File) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		return term.IsTerminal(int(f.Fd())), nil
+	}
+	return false, fmt.Errorf("%q is not one of auto, always, never", mode)
+}
+
+// colorize wraps s in the SGR code when enabled.
+func colorize(enabled bool, code, s string) string {
+	if !enabled {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+// statusColor is the color of a response status in the access log.
+func statusColor(status int) string {
+	switch {
+	case status >= 500:
+		return ansiRed
+	case status >= 400:
+		return ansiYellow
+	case status >= 300:
+		return ansiCyan
+	}
+	return ansiGreen
+}
+
+// colorStatus formats status for the access log.
+func colorStatus(enabled bool, status int) string {
+	return colorize(enabled, statusColor(status), strconv.Itoa(status))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// encoders maps each supported content coding to its streaming encoder.
+var encoders = map[string]func(io.Writer) io.WriteCloser{
+	"br": func(w io.Writer) io.WriteCloser {
+		// Level 5 keeps on-the-fly compression fast while still beating gzip
+		return brotli.NewWriterLevel(w, 5)
+	},
+	"gzip": func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	},
+}
+
+// parseCompressOrder parses a comma-separated --compress-order value and
+// returns the codings in enabled, in that order of preference.
+func parseCompressOrder(value string, enabled map[string]bool) ([]string, error) {
+	var order []string
+	for _, coding := range strings.Split(value, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		if encoders[coding] == nil {
+			return nil, fmt.Errorf("unsupported encoding %q (want br or gzip)", coding)
+		}
+		if enabled[coding] {
+			order = append(order, coding)
+		}
+	}
+	return order, nil
+}
+
+// incompressibleTypes are formats that are already compressed, so
+// compressing them again only burns CPU. They are never compressed,
+// whatever the client accepts.
+var incompressibleTypes = map[string]bool{
+	"image/png":                    true,
+	"image/jpeg":                   true,
+	"image/gif":                    true,
+	"image/webp":                   true,
+	"image/avif":                   true,
+	"video/mp4":                    true,
+	"video/webm":                   true,
+	"video/quicktime":              true,
+	"video/x-matroska":             true,
+	"audio/mpeg":                   true,
+	"audio/ogg":                    true,
+	"audio/flac":                   true,
+	"audio/mp4":                    true,
+	"application/pdf":              true,
+	"application/zip":              true,
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/zstd":             true,
+	// Unknown binary data is most often an archive or media file.
+	"application/octet-stream": true,
+}
+
+// isCompressible reports whether a response of mimeType is worth compressing.
+func isCompressible(mimeType string) bool {
+	base, _, _ := strings.Cut(mimeType, ";")
+	return !incompressibleTypes[strings.TrimSpace(strings.ToLower(base))]
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding header lists
+// coding with a non-zero quality.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, field := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(field), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if q, err := strconv.ParseFloat(value, 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// negotiateEncoding picks the content coding for a file response, or ""
+// to send it as is. The first of fs.encodings the client accepts wins.
+func (fs *FileServer) negotiateEncoding(r *http.Request, mimeType string, size int64) string {
+	if len(fs.encodings) == 0 || size < fs.compressMinSize || !isCompressible(mimeType) {
+		return ""
+	}
+	for _, coding := range fs.encodings {
+		if acceptsEncoding(r, coding) {
+			return coding
+		}
+	}
+	return ""
+}
+
+// sidecarExtensions maps content codings to the extension of precompressed
+// sidecar files, as in file.js.gz next to file.js.
+var sidecarExtensions = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// openSidecar returns an open precompressed sidecar of filePath in the
+// first of fs.sidecars that the client accepts, with its coding, or
+// a nil file when there is none.
+func (fs *FileServer) openSidecar(r *http.Request, filePath string) (openedFile, os.FileInfo, string) {
+	for _, coding := range fs.sidecars {
+		if !acceptsEncoding(r, coding) {
+			continue
+		}
+		file, err := fs.openFile(filePath + sidecarExtensions[coding])
+		if err != nil {
+			continue
+		}
+		info, err := file.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			file.Close()
+			continue
+		}
+		return file, info, coding
+	}
+	return nil, nil, ""
+}
+
+// compressWriter compresses a 200 response body on the fly. Other statuses
+// (304, errors) pass through untouched.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	enc         io.WriteCloser
+	wroteHeader bool
+}
+
+func newCompressWriter(w http.ResponseWriter, encoding string) *compressWriter {
+	return &compressWriter{ResponseWriter: w, encoding: encoding}
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	if status == http.StatusOK {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		// Ranges were dropped from the request; a compressed stream can't
+		// be resumed, whatever ServeContent advertised
+		h.Set("Accept-Ranges", "none")
+		cw.enc = encoders[cw.encoding](cw.ResponseWriter)
+	} else {
+		h.Del("Content-Encoding")
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Close flushes any buffered compressed output.
+func (cw *compressWriter) Close() error {
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCompressSkipsCompressedFormats(t *testing.T) {
+	dir := t.TempDir()
+	text := strings.Repeat("all work and no play\n", 200)
+	writeFiles(t, dir, map[string]string{"notes.txt": text, "photo.jpg": strings.Repeat("\xff\xd8", 2000)})
+	fs := newTestServer(dir)
+	fs.encodings = []string{"br", "gzip"}
+	fs.compressMinSize = 1024
+
+	for _, tc := range []struct {
+		accept, want string
+		decode       func(io.Reader) (io.Reader, error)
+	}{
+		{"gzip", "gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"br, gzip", "br", func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }},
+	} {
+		w := doRequest(fs, http.MethodGet, "/notes.txt", nil, "Accept-Encoding", tc.accept)
+		if got := w.Header().Get("Content-Encoding"); got != tc.want {
+			t.Fatalf(".txt with Accept-Encoding %q: Content-Encoding %q, want %q", tc.accept, got, tc.want)
+		}
+		reader, err := tc.decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, err := io.ReadAll(reader); err != nil || string(body) != text {
+			t.Errorf(".txt with Accept-Encoding %q: decoded %d bytes, error %v", tc.accept, len(body), err)
+		}
+
+		w = doRequest(fs, http.MethodGet, "/photo.jpg", nil, "Accept-Encoding", tc.accept)
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf(".jpg with Accept-Encoding %q: Content-Encoding %q, want none", tc.accept, got)
+		}
+		if w.Body.Len() != 4000 {
+			t.Errorf(".jpg with Accept-Encoding %q: %d bytes, want the 4000 stored", tc.accept, w.Body.Len())
+		}
+	}
+}
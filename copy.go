@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// maxCopyBuffer bounds --copy-buffer; every concurrent transfer holds one.
+const maxCopyBuffer = 64 << 20
+
+// newCopyBuffers returns a pool of size-byte buffers for --copy-buffer.
+func newCopyBuffers(size int) *sync.Pool {
+	return &sync.Pool{New: func() any {
+		buf := make([]byte, size)
+		return &buf
+	}}
+}
+
+// copy copies src to dst like io.Copy. With --copy-buffer it goes through a
+// pooled buffer of that size, even where dst could take the data itself
+// (os.File and net/http's ReadFrom use fixed 32KB buffers unless the kernel
+// can copy directly).
+func (fs *FileServer) copy(dst io.Writer, src io.Reader) (int64, error) {
+	if fs.copyBuffers == nil {
+		return io.Copy(dst, src)
+	}
+	buf := fs.copyBuffers.Get().(*[]byte)
+	defer fs.copyBuffers.Put(buf)
+	return io.CopyBuffer(writerOnly{dst}, src, *buf)
+}
+
+// writerOnly hides any ReadFrom method of the Writer, so io.CopyBuffer uses
+// the buffer it is given.
+type writerOnly struct {
+	io.Writer
+}
+
+// copyWriter routes the body copy of http.ServeContent through fs.copy.
+type copyWriter struct {
+	http.ResponseWriter
+	fs *FileServer
+}
+
+func (w copyWriter) ReadFrom(src io.Reader) (int64, error) {
+	return w.fs.copy(w.ResponseWriter, src)
+}
+
+// bodyWriter returns w for http.ServeContent, wrapped so that --copy-buffer
+// applies to file downloads too.
+func (fs *FileServer) bodyWriter(w http.ResponseWriter) http.ResponseWriter {
+	if fs.copyBuffers == nil {
+		return w
+	}
+	return copyWriter{w, fs}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkCopyBuffer downloads a 256MB file over loopback with the
+// default copy (sendfile where the kernel can) and with --copy-buffer at
+// several sizes.
+func BenchmarkCopyBuffer(b *testing.B) {
+	const size = 256 << 20
+	dir := b.TempDir()
+	file, err := os.Create(filepath.Join(dir, "big.bin"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	chunk := make([]byte, 1<<20)
+	for i := range chunk {
+		chunk[i] = byte(i * 31)
+	}
+	for written := 0; written < size; written += len(chunk) {
+		if _, err := file.Write(chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+	file.Close()
+
+	for _, bc := range []struct {
+		name   string
+		buffer int
+	}{
+		{"default", 0}, {"32KB", 32 << 10}, {"256KB", 256 << 10}, {"1MB", 1 << 20}, {"4MB", 4 << 20},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			fs := newTestServer(dir)
+			if bc.buffer > 0 {
+				fs.copyBuffers = newCopyBuffers(bc.buffer)
+			}
+			srv := httptest.NewServer(fs)
+			defer srv.Close()
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := http.Get(srv.URL + "/big.bin")
+				if err != nil {
+					b.Fatal(err)
+				}
+				n, err := io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if err != nil || n != size {
+					b.Fatalf("got %d bytes, error %v", n, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+)
+
+// defaultCSP is the Content-Security-Policy of served files unless --csp
+// says otherwise. Files can show images, media and styles but not run
+// scripts, so an uploaded HTML or SVG page can't act in the server's origin.
+const defaultCSP = "default-src 'none'; img-src 'self' data:; media-src 'self'; style-src 'self' 'unsafe-inline'; font-src 'self'; base-uri 'none'; form-action 'none'"
+
+// The generated pages allow exactly their own inline style and script by
+// hash. Images may come from anywhere over HTTPS, for README badges and the
+// like; raw HTML in Markdown is dropped, so that's as far as a document
+// gets.
+var (
+	listingCSP  = pageCSP(listingCSS, clientSortScript)
+	markdownCSP = pageCSP(markdownPageCSS, "")
+)
+
+// errorCSP covers error pages. --error-dir and --error-template pages are
+// the operator's own, so any inline style goes.
+const errorCSP = "default-src 'none'; img-src 'self' data:; style-src 'unsafe-inline'; base-uri 'none'; form-action 'none'"
+
+// pageCSP returns the policy for a generated page with the given inline
+// style and, if not empty, script.
+func pageCSP(style, script string) string {
+	csp := "default-src 'none'; style-src " + sourceHash(style) + "; img-src 'self' data: https:; media-src 'self'; base-uri 'none'; form-action 'self'"
+	if script != "" {
+		csp += "; script-src " + sourceHash(script)
+	}
+	return csp
+}
+
+// sourceHash returns the CSP hash source for an inline element's content.
+func sourceHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}
+
+// setSecurityHeaders sets the headers every response starts out with:
+// nosniff, so browsers keep to the Content-Type they are given, and the
+// --csp policy. Generated pages replace the policy with their own.
+func (fs *FileServer) setSecurityHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if fs.csp != "" {
+		w.Header().Set("Content-Security-Policy", fs.csp)
+	}
+}
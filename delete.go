@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// handleDelete removes the file at filePath for a DELETE request in
+// writable mode, without needing --webdav. It is gated like PUT: the path
+// has passed the access checks, must be writable under --write-prefix,
+// honours If-Match and If-Unmodified-Since, and is serialized with uploads
+// and moves of the same path. Folders are only removed when empty, so one
+// request can't wipe out a tree. It answers 204.
+func (fs *FileServer) handleDelete(w http.ResponseWriter, r *http.Request, filePath string) {
+	if !fs.writeAllowed(filePath) {
+		fs.writeForbidden(w, r)
+		return
+	}
+	if filePath == fs.servePath {
+		fs.serveError(w, r, http.StatusForbidden, "Forbidden: Cannot delete the root folder")
+		return
+	}
+
+	unlock := fs.lockUploadPath(filePath)
+	defer unlock()
+
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		fs.serveError(w, r, http.StatusNotFound, "Not Found")
+		return
+	}
+	if preconditionFailed(r, info) {
+		fs.serveError(w, r, http.StatusPreconditionFailed, "Precondition Failed: the file has changed")
+		return
+	}
+	if info.IsDir() {
+		if entries, err := os.ReadDir(filePath); err == nil && len(entries) > 0 {
+			fs.serveError(w, r, http.StatusConflict, "Conflict: Folder is not empty")
+			return
+		}
+	}
+	if err := os.Remove(filePath); err != nil {
+		log.Printf("Error deleting %s: %v", filePath, err)
+		fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error deleting file: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDelete(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"incoming/a.txt": "a", "incoming/full/b.txt": "b", "docs/c.txt": "c", "private/d.txt": "d",
+	})
+	os.Mkdir(filepath.Join(dir, "incoming", "empty"), 0755)
+	fs := newTestServer(dir)
+	fs.writable = true
+	fs.writePrefix = "/incoming"
+	fs.acl.Store(&accessList{
+		users: map[string]string{"alice": "pw"},
+		rules: []aclRule{{pattern: "/incoming/a.txt", users: []string{"alice"}}},
+	})
+
+	for _, tc := range []struct {
+		target string
+		header []string
+		status int
+		gone   bool
+	}{
+		{"/incoming/a.txt", nil, http.StatusUnauthorized, false},
+		{"/incoming/missing.txt", nil, http.StatusNotFound, false},
+		{"/docs/c.txt", nil, http.StatusForbidden, false}, // outside --write-prefix
+		{"/incoming/full", nil, http.StatusConflict, false},
+		{"/raw/incoming/empty", nil, http.StatusMethodNotAllowed, false},
+		{"/incoming/a.txt", []string{"Authorization", "Basic YWxpY2U6cHc=", "If-Match", `"stale"`}, http.StatusPreconditionFailed, false},
+		{"/incoming/a.txt", []string{"Authorization", "Basic YWxpY2U6cHc="}, http.StatusNoContent, true},
+		{"/incoming/empty", nil, http.StatusNoContent, true},
+	} {
+		w := doRequest(fs, http.MethodDelete, tc.target, nil, tc.header...)
+		if w.Code != tc.status {
+			t.Errorf("DELETE %s: status %d, want %d", tc.target, w.Code, tc.status)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(tc.target, "/raw")))
+		if _, err := os.Stat(path); os.IsNotExist(err) != tc.gone && tc.status != http.StatusNotFound {
+			t.Errorf("DELETE %s: removed %v, want %v", tc.target, os.IsNotExist(err), tc.gone)
+		}
+	}
+}
+
+func TestDeleteNeedsWritable(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a"})
+	fs := newTestServer(dir)
+	w := doRequest(fs, http.MethodDelete, "/a.txt", nil)
+	if w.Code != http.StatusMethodNotAllowed || strings.Contains(w.Header().Get("Allow"), "DELETE") {
+		t.Errorf("read-only DELETE: status %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); err != nil {
+		t.Error("read-only DELETE removed the file")
+	}
+
+	fs.writable = true
+	if allow := doRequest(fs, http.MethodOptions, "/a.txt", nil).Header().Get("Allow"); !strings.Contains(allow, "DELETE") {
+		t.Errorf("writable Allow = %q, missing DELETE", allow)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pageCSS is the styling shared by the directory listing and the other
+// generated HTML pages.
+const pageCSS = `        body { font-family: Arial, sans-serif; margin: 20px; }
+        h1 { color: #333; }
+        a { text-decoration: none; color: #0066cc; }
+        a:hover { text-decoration: underline; }`
+
+var defaultErrorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Status}} {{.StatusText}}</title>
+    <style>
+` + pageCSS + `
+        .message { color: #666; }
+    </style>
+</head>
+<body>
+    <h1>{{.Status}} {{.StatusText}}</h1>
+    <p class="message">{{.Message}}</p>
+    <p><a href="{{.Root}}">Back to the root directory</a></p>
+</body>
+</html>`))
+
+// errorPage is the data passed to error templates.
+type errorPage struct {
+	Status     int    `json:"status"`
+	StatusText string `json:"error"`
+	Message    string `json:"message"`
+	Root       string `json:"-"` // URL of the served root, honouring --base-url
+}
+
+// loadErrorTemplate parses a custom --error-template file.
+func loadErrorTemplate(path string) (*template.Template, error) {
+	return template.New(filepath.Base(path)).ParseFiles(path)
+}
+
+// serveError answers with status and msg in the representation the client
+// asked for: JSON for API clients, a themed page for browsers, and plain
+// text otherwise. Browsers get <status>.html from --error-dir if present,
+// else the --error-template, else the built-in page.
+func (fs *FileServer) serveError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	h := w.Header()
+	h.Del("Content-Disposition")
+	h.Del("Content-Encoding")
+	h.Del("ETag")
+	h.Del("Last-Modified")
+	h.Del("Cache-Control")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", errorCSP)
+
+	page := errorPage{Status: status, StatusText: http.StatusText(status), Message: msg, Root: fs.baseURL + "/"}
+	accept := r.Header.Get("Accept")
+
+	switch {
+	case strings.Contains(accept, "text/html"):
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		if fs.errorDir != "" {
+			if body, err := os.ReadFile(filepath.Join(fs.errorDir, strconv.Itoa(status)+".html")); err == nil {
+				w.WriteHeader(status)
+				w.Write(body)
+				return
+			}
+		}
+		tmpl := fs.errorTemplate
+		if tmpl == nil {
+			tmpl = defaultErrorTemplate
+		}
+		w.WriteHeader(status)
+		if err := tmpl.Execute(w, page); err != nil {
+			log.Printf("Error rendering error page: %v", err)
+		}
+	case strings.Contains(accept, "application/json"):
+		h.Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(page)
+	default:
+		http.Error(w, msg, status)
+	}
+}
+
+// writeRequestError sends err to the client, using the status of a
+// requestError and 500 for anything else.
+func (fs *FileServer) writeRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	if reqErr, ok := err.(*requestError); ok {
+		if reqErr.status == http.StatusUnauthorized && fs.wantsLoginPage(r) {
+			fs.redirectToLogin(w, r)
+			return
+		}
+		if reqErr.status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Basic realm="simple-http-server", charset="UTF-8"`)
+		}
+		fs.serveError(w, r, reqErr.status, reqErr.msg)
+		return
+	}
+	fs.serveError(w, r, http.StatusInternalServerError, err.Error())
+}
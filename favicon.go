@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultFavicon is answered for /favicon.ico when the served folder has
+// none, so the request browsers make on every page doesn't end in a 404.
+//
+//go:embed favicon.ico
+var defaultFavicon []byte
+
+// faviconModTime stands in for the embedded icon's modification time; it
+// only has to be stable for the life of the process.
+var faviconModTime = time.Now()
+
+// checkFavicon validates a --favicon path.
+func checkFavicon(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return errors.New("not a regular file")
+	}
+	return checkReadable(path, true)
+}
+
+// isFaviconRequest reports whether a request for the missing absPath should
+// get the fallback icon.
+func (fs *FileServer) isFaviconRequest(r *http.Request, absPath string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return fs.rootRelative(absPath) == "/favicon.ico"
+}
+
+// serveFavicon answers for a favicon.ico that doesn't exist in the root,
+// with the --favicon file if one was given and the embedded icon otherwise.
+func (fs *FileServer) serveFavicon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if fs.favicon != "" {
+		fs.sendFile(w, r, fs.favicon, getMimeType(filepath.Base(fs.favicon)), "inline")
+		return
+	}
+	w.Header().Set("Content-Type", "image/x-icon")
+	http.ServeContent(w, r, "favicon.ico", faviconModTime, bytes.NewReader(defaultFavicon))
+}
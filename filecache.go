@@ -0,0 +1,110 @@
+package main
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileCache is an LRU cache of small file contents, bounded by the total
+// number of bytes held. Entries are validated against a fresh Stat on every
+// lookup, so edited files are re-read. A nil *fileCache caches nothing.
+type fileCache struct {
+	maxBytes     int64
+	maxFileBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type cachedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+	data    []byte
+}
+
+func newFileCache(maxBytes, maxFileBytes int64) *fileCache {
+	return &fileCache{
+		maxBytes:     maxBytes,
+		maxFileBytes: maxFileBytes,
+		order:        list.New(),
+		entries:      make(map[string]*list.Element),
+	}
+}
+
+// fits reports whether a file of size bytes would be cached.
+func (c *fileCache) fits(size int64) bool {
+	return c != nil && size <= c.maxFileBytes && size <= c.maxBytes
+}
+
+// get returns the cached contents of path and its current FileInfo if the
+// cached copy is still current. Only a Stat is needed, the file itself is
+// not opened.
+func (c *fileCache) get(path string) ([]byte, os.FileInfo, bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[path]
+	if !ok {
+		return nil, nil, false
+	}
+	entry := elem.Value.(*cachedFile)
+	if entry.size != info.Size() || !entry.modTime.Equal(info.ModTime()) {
+		c.remove(elem)
+		return nil, nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.data, info, true
+}
+
+// put stores data as the contents of path, evicting the least recently
+// used files until the cache is back under its limit.
+func (c *fileCache) put(path string, info os.FileInfo, data []byte) {
+	if !c.fits(int64(len(data))) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[path]; ok {
+		c.remove(elem)
+	}
+	c.entries[path] = c.order.PushFront(&cachedFile{path: path, size: info.Size(), modTime: info.ModTime(), data: data})
+	c.size += int64(len(data))
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// forget drops the cached contents of path and of anything below it.
+func (c *fileCache) forget(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, elem := range c.entries {
+		if isWithin(name, path) {
+			c.remove(elem)
+		}
+	}
+}
+
+// remove drops elem; c.mu must be held.
+func (c *fileCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*cachedFile)
+	delete(c.entries, entry.path)
+	c.size -= int64(len(entry.data))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileCacheHitMatchesMiss(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"page.html": strings.Repeat("<p>cached</p>\n", 500)})
+	plain := newTestServer(dir)
+	cached := newTestServer(dir)
+	cached.fileCache = newFileCache(1<<20, 64<<10)
+
+	etag := doRequest(plain, http.MethodGet, "/page.html", nil).Header().Get("ETag")
+	requests := []struct {
+		name   string
+		header []string
+		status int
+	}{
+		{"full", nil, http.StatusOK},
+		{"range", []string{"Range", "bytes=100-199"}, http.StatusPartialContent},
+		{"suffix range", []string{"Range", "bytes=-50"}, http.StatusPartialContent},
+		{"If-None-Match", []string{"If-None-Match", etag}, http.StatusNotModified},
+		{"If-Range", []string{"Range", "bytes=0-9", "If-Range", etag}, http.StatusPartialContent},
+		{"stale If-Range", []string{"Range", "bytes=0-9", "If-Range", `"old"`}, http.StatusOK},
+	}
+	for i, tc := range requests {
+		want := doRequest(plain, http.MethodGet, "/page.html", nil, tc.header...)
+		got := doRequest(cached, http.MethodGet, "/page.html", nil, tc.header...)
+		if _, _, ok := cached.fileCache.get(filepath.Join(dir, "page.html")); !ok {
+			t.Fatalf("%s: file not in the cache", tc.name)
+		}
+		outcome := "hit"
+		if i == 0 {
+			outcome = "miss"
+		}
+		if want.Code != tc.status || got.Code != want.Code {
+			t.Errorf("%s (cache %s): status %d, uncached %d, want %d", tc.name, outcome, got.Code, want.Code, tc.status)
+		}
+		if got.Body.String() != want.Body.String() {
+			t.Errorf("%s (cache %s): body differs", tc.name, outcome)
+		}
+		for _, header := range []string{"ETag", "Last-Modified", "Content-Type", "Content-Length", "Content-Range", "Accept-Ranges"} {
+			if got.Header().Get(header) != want.Header().Get(header) {
+				t.Errorf("%s (cache %s): %s = %q, uncached %q", tc.name, outcome, header, got.Header().Get(header), want.Header().Get(header))
+			}
+		}
+	}
+}
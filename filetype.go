@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// fileTypeGroups are the ?type= values for listings, in chip order, with
+// their labels.
+var fileTypeGroups = []struct {
+	name, label string
+}{
+	{"image", "Images"},
+	{"video", "Videos"},
+	{"audio", "Audio"},
+	{"document", "Documents"},
+	{"archive", "Archives"},
+}
+
+// documentTypes and archiveTypes classify the application/ and text/ types
+// getMimeType knows; image/, video/ and audio/ go by their prefix.
+var documentTypes = map[string]bool{
+	"application/pdf":               true,
+	"application/rtf":               true,
+	"application/msword":            true,
+	"application/vnd.ms-excel":      true,
+	"application/vnd.ms-powerpoint": true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         true,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": true,
+	"application/vnd.oasis.opendocument.text":                                   true,
+	"application/vnd.oasis.opendocument.spreadsheet":                            true,
+	"text/plain":    true,
+	"text/markdown": true,
+	"text/csv":      true,
+}
+
+var archiveTypes = map[string]bool{
+	"application/zip":              true,
+	"application/gzip":             true,
+	"application/x-tar":            true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+}
+
+// isFileTypeGroup reports whether name is a known ?type= value.
+func isFileTypeGroup(name string) bool {
+	for _, group := range fileTypeGroups {
+		if group.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fileTypeGroup returns the group of a file by the MIME type it is served
+// with, or "" if it belongs to none.
+func fileTypeGroup(name string) string {
+	mimeType, _, _ := strings.Cut(getMimeType(name), ";")
+	mimeType = strings.TrimSpace(mimeType)
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	case documentTypes[mimeType]:
+		return "document"
+	case archiveTypes[mimeType]:
+		return "archive"
+	}
+	return ""
+}
+
+// filterByType keeps the files in group and all directories.
+func filterByType(files []FileInfo, group string) []FileInfo {
+	var matched []FileInfo
+	for _, f := range files {
+		if f.IsDir || fileTypeGroup(f.Name) == group {
+			matched = append(matched, f)
+		}
+	}
+	return matched
+}
+
+// typeChip is a ?type= filter link above a listing.
+type typeChip struct {
+	Label  string
+	URL    string
+	Active bool
+}
+
+// typeChips builds the filter links for listing, starting with "All". They
+// keep the search and sort but go back to the first page.
+func typeChips(listing *DirectoryListing) []typeChip {
+	q := listingQuery(listing)
+	q.Del("type")
+	chips := []typeChip{{Label: "All", URL: "?" + q.Encode(), Active: listing.Type == ""}}
+	for _, group := range fileTypeGroups {
+		q.Set("type", group.name)
+		chips = append(chips, typeChip{Label: group.label, URL: "?" + q.Encode(), Active: listing.Type == group.name})
+	}
+	return chips
+}
+
+// listingQuery returns the query parameters that select what listing
+// shows: search, type filter, sort order and time display.
+func listingQuery(listing *DirectoryListing) url.Values {
+	q := url.Values{}
+	if listing.Search != "" {
+		q.Set("search", listing.Search)
+	}
+	if listing.Type != "" {
+		q.Set("type", listing.Type)
+	}
+	if listing.Sort != "name" {
+		q.Set("sort", listing.Sort)
+	}
+	if listing.Desc {
+		q.Set("order", "desc")
+	}
+	if listing.Relative != "" {
+		q.Set("relative", listing.Relative)
+	}
+	return q
+}
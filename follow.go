@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// followPollInterval is how often a followed file is checked for growth.
+const followPollInterval = 500 * time.Millisecond
+
+// serveFollow streams the file at filePath like tail -f: the current
+// contents first, then whatever is appended, until the client goes away.
+// A file that shrinks (log rotation by truncation) is followed from its
+// new start.
+func (fs *FileServer) serveFollow(w http.ResponseWriter, r *http.Request, filePath string) {
+	file, err := fs.openFile(filePath)
+	if err != nil {
+		fs.serveReadError(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	mimeType := getMimeType(filepath.Base(filePath))
+	if isTextMime(mimeType) {
+		mimeType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// The stream is open-ended, so --write-timeout must not cut it off
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	w = fs.throttle(w, r)
+
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+
+	var offset int64
+	for {
+		n, err := fs.copy(w, file)
+		offset += n
+		if err != nil {
+			return
+		}
+		if n > 0 {
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		if info, err := file.Stat(); err != nil {
+			return
+		} else if info.Size() < offset {
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return
+			}
+			offset = 0
+		}
+	}
+}
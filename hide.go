@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// parseHidePatterns validates --hide globs. A pattern without a slash,
+// like *.bak or node_modules, matches a name at any depth; one with a
+// slash, like /private/*.key, matches the path from the serve root.
+func parseHidePatterns(values []string) ([]string, error) {
+	var patterns []string
+	for _, value := range values {
+		pattern := strings.TrimSuffix(value, "/")
+		if strings.Contains(pattern, "/") {
+			pattern = "/" + strings.TrimPrefix(pattern, "/")
+		}
+		if pattern == "" || pattern == "/" {
+			return nil, fmt.Errorf("empty pattern %q", value)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", value, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// isHidden reports whether rel, a root-relative path as returned by
+// rootRelative, matches a --hide pattern. Everything below a hidden
+// directory is hidden too.
+func (fs *FileServer) isHidden(rel string) bool {
+	if len(fs.hidePatterns) == 0 || rel == "/" {
+		return false
+	}
+	for _, pattern := range fs.hidePatterns {
+		if matchesPattern(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPattern reports whether rel or one of its parent directories
+// matches pattern, as returned by parseHidePatterns.
+func matchesPattern(pattern, rel string) bool {
+	prefix := ""
+	for _, name := range strings.Split(strings.TrimPrefix(rel, "/"), "/") {
+		prefix += "/" + name
+		subject := name
+		if strings.HasPrefix(pattern, "/") {
+			subject = prefix
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hidingFileSystem keeps --hide matches and files --allow-ext leaves out
+// out of WebDAV, both for direct access and in collection listings. The
+// listings also leave out what the request couldn't open itself, see
+// hidingFile.Readdir.
+type hidingFileSystem struct {
+	webdav.FileSystem
+	server *FileServer
+}
+
+func (h hidingFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if h.server.isHidden(path.Clean("/" + name)) {
+		return nil, os.ErrNotExist
+	}
+	f, err := h.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if flag == os.O_RDONLY {
+		if info, err := f.Stat(); err == nil && !h.server.extAllowed(name, info.IsDir()) {
+			f.Close()
+			return nil, os.ErrNotExist
+		}
+	}
+	request, _ := ctx.Value(webdavRequestKey{}).(*http.Request)
+	return hidingFile{File: f, server: h.server, name: path.Clean("/" + name), request: request}, nil
+}
+
+func (h hidingFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if h.server.isHidden(path.Clean("/" + name)) {
+		return nil, os.ErrNotExist
+	}
+	info, err := h.FileSystem.Stat(ctx, name)
+	if err == nil && !h.server.extAllowed(name, info.IsDir()) {
+		return nil, os.ErrNotExist
+	}
+	return info, err
+}
+
+type hidingFile struct {
+	webdav.File
+	server  *FileServer
+	name    string
+	request *http.Request // nil outside serveWebDAV
+}
+
+// Readdir leaves out the entries a PROPFIND Depth: 1 must not reveal:
+// --hide and --allow-ext matches, entries the request's user, network or
+// share link may not access (the checkAccess a request for the entry
+// itself gets), and directories past --max-depth.
+func (f hidingFile) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	visible := infos[:0]
+	for _, info := range infos {
+		name := path.Join(f.name, info.Name())
+		if f.server.isHidden(name) || !f.server.extAllowed(info.Name(), info.IsDir()) {
+			continue
+		}
+		if f.request != nil {
+			absPath := filepath.Join(f.server.servePath, filepath.FromSlash(name))
+			if f.server.checkAccess(f.request, absPath) != nil || (info.IsDir() && !f.server.depthAllowed(absPath, true)) {
+				continue
+			}
+		}
+		visible = append(visible, info)
+	}
+	return visible, err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// hotlinkSignature signs a --sign-hotlink token for rel, a root-relative
+// path as returned by rootRelative. Tokens don't expire; changing
+// --hotlink-secret revokes them all.
+func hotlinkSignature(secret []byte, rel string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("hotlink\x00" + rel))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hotlinkAllowed applies --hotlink-allow to a file request: the Referer
+// must be a page on the server's own host or on one of the allowed hosts,
+// unless the request carries a ?hotlink= token for the file. Without
+// --hotlink-allow every request passes.
+func (fs *FileServer) hotlinkAllowed(r *http.Request, absPath string) bool {
+	if len(fs.hotlinkHosts) == 0 {
+		return true
+	}
+	if token := r.URL.Query().Get("hotlink"); token != "" && fs.hotlinkSecret != nil {
+		if hmac.Equal([]byte(token), []byte(hotlinkSignature(fs.hotlinkSecret, fs.rootRelative(absPath)))) {
+			return true
+		}
+	}
+	referer, err := url.Parse(r.Referer())
+	if err != nil || referer.Host == "" {
+		return false
+	}
+	host := strings.ToLower(referer.Hostname())
+	own := r.Host
+	if name, _, err := net.SplitHostPort(own); err == nil {
+		own = name
+	}
+	if strings.EqualFold(own, host) {
+		return true
+	}
+	for _, allowed := range fs.hotlinkHosts {
+		if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// hotlinkPath returns the escaped URL path and query of a link to name,
+// below the root, that --hotlink-allow serves without a Referer.
+func hotlinkPath(secret []byte, name string) string {
+	rel := path.Clean("/" + name)
+	query := url.Values{}
+	query.Set("hotlink", hotlinkSignature(secret, rel))
+	return (&url.URL{Path: rel}).String() + "?" + query.Encode()
+}
+
+// parseHotlinkHosts normalizes --hotlink-allow values, which may be
+// repeated or comma-separated, to lower-case host names.
+func parseHotlinkHosts(values []string) []string {
+	var hosts []string
+	for _, value := range values {
+		for _, host := range strings.Split(value, ",") {
+			if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHotlinkExemptFileServesWithoutReferer(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"img/logo.png": "logo", "img/photo.png": "photo"})
+	fs := newTestServer(dir)
+	fs.hotlinkHosts = parseHotlinkHosts([]string{"blog.example.com, Docs.example.com"})
+	fs.hotlinkSecret = []byte("key")
+
+	exempt := hotlinkPath(fs.hotlinkSecret, "img/logo.png")
+	if w := doRequest(fs, http.MethodGet, exempt, nil); w.Code != http.StatusOK || w.Body.String() != "logo" {
+		t.Errorf("exempt file without a Referer: status %d, body %q", w.Code, w.Body)
+	}
+	if w := doRequest(fs, http.MethodGet, "/img/photo.png", nil); w.Code != http.StatusForbidden {
+		t.Errorf("protected file without a Referer: status %d, want 403", w.Code)
+	}
+
+	// A token only covers the file it was made for
+	query := exempt[strings.Index(exempt, "?"):]
+	if w := doRequest(fs, http.MethodGet, "/img/photo.png"+query, nil); w.Code != http.StatusForbidden {
+		t.Errorf("another file's token: status %d, want 403", w.Code)
+	}
+	if w := doRequest(fs, http.MethodGet, "/img/photo.png?hotlink=00", nil); w.Code != http.StatusForbidden {
+		t.Errorf("bad token: status %d, want 403", w.Code)
+	}
+}
+
+func TestHotlinkReferers(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"photo.png": "photo"})
+	fs := newTestServer(dir)
+	fs.hotlinkHosts = parseHotlinkHosts([]string{"blog.example.com"})
+
+	for referer, want := range map[string]int{
+		"http://example.com/page":         http.StatusOK, // httptest's own host
+		"https://blog.example.com/post/1": http.StatusOK,
+		"https://BLOG.example.com:8443/":  http.StatusOK,
+		"https://evil.example.net/":       http.StatusForbidden,
+		"https://blog.example.com.evil/":  http.StatusForbidden,
+		"not a url":                       http.StatusForbidden,
+	} {
+		if w := doRequest(fs, http.MethodGet, "/photo.png", nil, "Referer", referer); w.Code != want {
+			t.Errorf("Referer %q: status %d, want %d", referer, w.Code, want)
+		}
+	}
+
+	// Listings stay reachable, so visitors can still browse to the files
+	if w := doRequest(fs, http.MethodGet, "/", nil); w.Code != http.StatusOK {
+		t.Errorf("listing without a Referer: status %d", w.Code)
+	}
+}
+
+func TestHotlinkSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"logo.png": "logo"})
+	fs := newTestServer(filepath.Join(dir, "logo.png"))
+	fs.singleFile = true
+	fs.hotlinkHosts = parseHotlinkHosts([]string{"blog.example.com"})
+	fs.hotlinkSecret = []byte("key")
+
+	token := hotlinkPath(fs.hotlinkSecret, "/")
+	query := token[strings.Index(token, "?"):]
+	if w := doRequest(fs, http.MethodGet, "/logo.png"+query, nil); w.Code != http.StatusOK {
+		t.Errorf("token for the root: status %d, want 200", w.Code)
+	}
+	if w := doRequest(fs, http.MethodGet, "/logo.png", nil); w.Code != http.StatusForbidden {
+		t.Errorf("no Referer or token: status %d, want 403", w.Code)
+	}
+}
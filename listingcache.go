@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxCachedListingBytes bounds the --cache-listings cache; it is reset when
+// full.
+const maxCachedListingBytes = 64 << 20
+
+// listingKey identifies one rendering of a directory: the directory, a
+// signature of its entries and the query that picked sort order, page and
+// filters.
+type listingKey struct {
+	dir       string
+	signature uint64
+	query     string
+}
+
+type cachedListing struct {
+	etag    string
+	page    []byte
+	created time.Time // for the Age header of cache hits
+}
+
+// listingCache remembers generated listing pages for --cache-listings. A
+// changed entry changes the signature, so stale pages miss the cache. A nil
+// *listingCache caches nothing.
+type listingCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[listingKey]cachedListing
+}
+
+func (c *listingCache) get(key listingKey) (cachedListing, bool) {
+	if c == nil {
+		return cachedListing{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+func (c *listingCache) put(key listingKey, etag string, page []byte) {
+	if c == nil || len(page) > maxCachedListingBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || c.size+len(page) > maxCachedListingBytes {
+		c.entries = make(map[listingKey]cachedListing)
+		c.size = 0
+	}
+	if old, ok := c.entries[key]; ok {
+		c.size -= len(old.page)
+	}
+	c.entries[key] = cachedListing{etag: etag, page: page, created: time.Now()}
+	c.size += len(page)
+}
+
+// forget drops the pages of path and of the directories below it.
+func (c *listingCache) forget(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if isWithin(key.dir, path) {
+			delete(c.entries, key)
+			c.size -= len(entry.page)
+		}
+	}
+}
+
+// listingSignature hashes the name, type, size and ModTime of every entry
+// of a directory, hidden ones included since --hide-empty-dirs looks at
+// them.
+type listingSignature struct {
+	h hash.Hash64
+}
+
+func newListingSignature() listingSignature {
+	return listingSignature{fnv.New64a()}
+}
+
+func (s listingSignature) add(name string, isDir bool, size int64, modTime int64) {
+	fmt.Fprintf(s.h, "%s\x00%t\x00%d\x00%d\x00", name, isDir, size, modTime)
+}
+
+// cachesListing reports whether the listing for r may come from the
+// --cache-listings cache, which holds HTML pages. Relative times go stale
+// by the minute.
+func (fs *FileServer) cachesListing(r *http.Request) bool {
+	if fs.listingPages == nil || wantsTextListing(r) || wantsJSONListing(r) {
+		return false
+	}
+	switch r.URL.Query().Get("relative") {
+	case "true":
+		return false
+	case "false":
+		return true
+	}
+	return !fs.relativeTime
+}
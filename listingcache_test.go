@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCachedListingMatchesUncached(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "b.pdf": "bb", "sub/c.txt": "c"})
+	plain := newTestServer(dir)
+	cached := newTestServer(dir)
+	cached.listingPages = &listingCache{}
+
+	for _, target := range []string{"/", "/?sort=size&order=desc", "/?search=a", "/sub/"} {
+		want := doRequest(plain, http.MethodGet, target, nil)
+		for _, outcome := range []string{"miss", "hit"} {
+			got := doRequest(cached, http.MethodGet, target, nil)
+			if !bytes.Equal(got.Body.Bytes(), want.Body.Bytes()) {
+				t.Errorf("%s: cache %s body differs from the uncached listing", target, outcome)
+			}
+			for _, header := range []string{"ETag", "Content-Type", "Content-Security-Policy", "Vary"} {
+				if got.Header().Get(header) != want.Header().Get(header) {
+					t.Errorf("%s: cache %s %s = %q, uncached %q", target, outcome, header, got.Header().Get(header), want.Header().Get(header))
+				}
+			}
+		}
+	}
+	if len(cached.listingPages.entries) == 0 {
+		t.Fatal("nothing was cached")
+	}
+
+	// A new file, and a changed one, must show up right away
+	writeFiles(t, dir, map[string]string{"new.txt": "n"})
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(dir, "a.txt"), later, later)
+	got := doRequest(cached, http.MethodGet, "/", nil)
+	want := doRequest(plain, http.MethodGet, "/", nil)
+	if !strings.Contains(got.Body.String(), "new.txt") || !bytes.Equal(got.Body.Bytes(), want.Body.Bytes()) {
+		t.Error("the cached listing wasn't invalidated by the change")
+	}
+}
+
+func TestCachedListingAge(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a"})
+	fs := newTestServer(dir)
+	fs.listingPages = &listingCache{}
+
+	if w := doRequest(fs, http.MethodGet, "/", nil); w.Header().Get("Age") != "" {
+		t.Errorf("freshly generated listing has Age %q", w.Header().Get("Age"))
+	}
+	w := doRequest(fs, http.MethodGet, "/", nil)
+	if age, err := strconv.Atoi(w.Header().Get("Age")); err != nil || age < 1 {
+		t.Errorf("cache hit has Age %q, want a positive number", w.Header().Get("Age"))
+	}
+}
+
+// BenchmarkDirectoryListing lists 30,000 files 10,000 to a page, with and
+// without --cache-listings.
+func BenchmarkDirectoryListing(b *testing.B) {
+	dir := b.TempDir()
+	for i := 0; i < 30000; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%05d.txt", i)), nil, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, cached := range []bool{false, true} {
+		name := "uncached"
+		fs := newTestServer(dir)
+		if cached {
+			name = "cached"
+			fs.listingPages = &listingCache{}
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if w := doRequest(fs, http.MethodGet, "/?per_page=10000", nil); w.Code != http.StatusOK {
+					b.Fatalf("status %d", w.Code)
+				}
+			}
+		})
+	}
+}
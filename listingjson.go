@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listingJSONVersion is bumped whenever a field of listingJSON changes
+// meaning or goes away; new fields may appear within a version.
+const listingJSONVersion = 1
+
+// listingJSON is the JSON form of a directory listing, for ?format=json or
+// Accept: application/json from a client that doesn't also take HTML:
+//
+//	{
+//	  "version": 1,
+//	  "path": "/docs/",        // below the root, without --base-url
+//	  "sort": "name",          // name, size or modified
+//	  "order": "asc",          // asc or desc
+//	  "search": "report",      // ?search=, "" for none
+//	  "type": "document",      // ?type= group, "" for all files
+//	  "page": 1,
+//	  "pages": 3,
+//	  "per_page": 500,
+//	  "total": 1234,           // entries matching search and type, all pages
+//	  "files": 1200,
+//	  "dirs": 34,
+//	  "total_size": 1048576,   // of the matching files, left out with --hide-size
+//	  "truncated": false,      // --max-listing-entries cut the entries short
+//	  "items": [
+//	    {"name": "a.pdf", "dir": false, "size": 4096,
+//	     "modified": "2024-05-01T12:00:00Z", "url": "/docs/a.pdf"}
+//	  ]
+//	}
+//
+// Items are the current page in the applied order. "size" and "modified"
+// are left out with --hide-size and --hide-mtime, and "url" for
+// directories past --max-depth.
+type listingJSON struct {
+	Version   int            `json:"version"`
+	Path      string         `json:"path"`
+	Sort      string         `json:"sort"`
+	Order     string         `json:"order"`
+	Search    string         `json:"search"`
+	Type      string         `json:"type"`
+	Page      int            `json:"page"`
+	Pages     int            `json:"pages"`
+	PerPage   int            `json:"per_page"`
+	Total     int            `json:"total"`
+	Files     int            `json:"files"`
+	Dirs      int            `json:"dirs"`
+	TotalSize *int64         `json:"total_size,omitempty"`
+	Truncated bool           `json:"truncated"`
+	Items     []listingEntry `json:"items"`
+}
+
+type listingEntry struct {
+	Name     string     `json:"name"`
+	Dir      bool       `json:"dir"`
+	Size     *int64     `json:"size,omitempty"`
+	Modified *time.Time `json:"modified,omitempty"`
+	URL      string     `json:"url,omitempty"`
+}
+
+// wantsJSONListing reports whether a directory request asks for
+// listingJSON.
+func wantsJSONListing(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "json" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// writeJSONListing sends listing, already sorted and paginated, as
+// listingJSON, with Link headers to the other pages.
+func writeJSONListing(w http.ResponseWriter, r *http.Request, listing *DirectoryListing) {
+	body := listingJSON{
+		Version: listingJSONVersion,
+		Path:    "/",
+		Sort:    listing.Sort,
+		Order:   "asc",
+		Search:  listing.Search,
+		Type:    listing.Type,
+		Page:    listing.Page,
+		Pages:   listing.Pages,
+		PerPage: listing.PerPage,
+		Total:   listing.FileCount + listing.DirCount,
+		Files:   listing.FileCount,
+		Dirs:    listing.DirCount,
+		Items:   make([]listingEntry, 0, len(listing.Files)),
+
+		Truncated: listing.Total > 0,
+	}
+	if dir := strings.Trim(listing.Path, "/"); dir != "" {
+		body.Path = "/" + dir + "/"
+	}
+	if listing.Desc {
+		body.Order = "desc"
+	}
+	if !listing.HideSize {
+		body.TotalSize = &listing.TotalSize
+	}
+	for _, f := range listing.Files {
+		entry := listingEntry{Name: f.Name, Dir: f.IsDir, URL: f.URL}
+		if !listing.HideSize && !f.IsDir {
+			entry.Size = &f.Size
+		}
+		if !listing.HideMTime {
+			modTime := f.ModTime.UTC()
+			entry.Modified = &modTime
+		}
+		body.Items = append(body.Items, entry)
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if links := pageLinks(r, listing); links != "" {
+		w.Header().Set("Link", links)
+	}
+	if r.Method != http.MethodHead {
+		w.Write(append(data, '\n'))
+	}
+}
+
+// pageLinks returns the RFC 8288 Link header value pointing to the first,
+// previous, next and last pages of a paginated listing, or "" when it fits
+// on one page. The targets keep the search, filter and sort of the request.
+func pageLinks(r *http.Request, listing *DirectoryListing) string {
+	if listing.Pages <= 1 {
+		return ""
+	}
+	link := func(page int, rel string) string {
+		q := listingQuery(listing)
+		if r.URL.Query().Get("format") == "json" {
+			q.Set("format", "json")
+		}
+		q.Set("page", strconv.Itoa(page))
+		if listing.PerPage != defaultPerPage {
+			q.Set("per_page", strconv.Itoa(listing.PerPage))
+		}
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.EscapedPath(), q.Encode(), rel)
+	}
+	links := []string{link(1, "first")}
+	if listing.Page > 1 {
+		links = append(links, link(listing.Page-1, "prev"))
+	}
+	if listing.Page < listing.Pages {
+		links = append(links, link(listing.Page+1, "next"))
+	}
+	links = append(links, link(listing.Pages, "last"))
+	return strings.Join(links, ", ")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONListingLinkHeaders(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"docs/a.txt": "a", "docs/b.txt": "b", "docs/c.txt": "c", "docs/d.txt": "d", "docs/e.txt": "e",
+	})
+	fs := newTestServer(dir)
+
+	w := doRequest(fs, http.MethodGet, "/docs/?format=json&per_page=2&page=2&sort=size", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var body listingJSON
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Page != 2 || body.Pages != 3 {
+		t.Fatalf("page %d of %d, want 2 of 3", body.Page, body.Pages)
+	}
+
+	want := []string{
+		`</docs/?format=json&page=1&per_page=2&sort=size>; rel="first"`,
+		`</docs/?format=json&page=1&per_page=2&sort=size>; rel="prev"`,
+		`</docs/?format=json&page=3&per_page=2&sort=size>; rel="next"`,
+		`</docs/?format=json&page=3&per_page=2&sort=size>; rel="last"`,
+	}
+	if got := w.Header().Get("Link"); got != strings.Join(want, ", ") {
+		t.Errorf("Link = %s\nwant %s", got, strings.Join(want, ", "))
+	}
+
+	// The first page has no prev, the last no next
+	w = doRequest(fs, http.MethodGet, "/docs/?per_page=2", nil, "Accept", "application/json")
+	if got := w.Header().Get("Link"); strings.Contains(got, `rel="prev"`) || !strings.Contains(got, `</docs/?page=2&per_page=2>; rel="next"`) {
+		t.Errorf("first page Link = %s", got)
+	}
+	w = doRequest(fs, http.MethodGet, "/docs/?format=json&per_page=2&page=3", nil)
+	if got := w.Header().Get("Link"); strings.Contains(got, `rel="next"`) || !strings.Contains(got, `page=2&per_page=2>; rel="prev"`) {
+		t.Errorf("last page Link = %s", got)
+	}
+}
+
+func TestJSONListingOnePageHasNoLinks(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a"})
+	w := doRequest(newTestServer(dir), http.MethodGet, "/?format=json", nil)
+	if got := w.Header().Get("Link"); got != "" {
+		t.Errorf("Link = %q for a single page", got)
+	}
+}
+
+func TestHideEmptyDirs(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"full/a.txt": "a", "only-hidden/.keep": "", "top.txt": "t"})
+	if err := os.Mkdir(filepath.Join(dir, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fs := newTestServer(dir)
+	fs.hidePatterns = []string{".*"}
+	fs.hideEmptyDirs = true
+
+	page := doRequest(fs, http.MethodGet, "/", nil).Body.String()
+	if !strings.Contains(page, `href="/full/"`) || !strings.Contains(page, `href="/top.txt"`) {
+		t.Errorf("listing lost a non-empty entry:\n%s", page)
+	}
+	for _, name := range []string{"empty", "only-hidden"} {
+		if strings.Contains(page, `href="/`+name+`/"`) {
+			t.Errorf("listing shows %s/", name)
+		}
+	}
+
+	var body listingJSON
+	if err := json.Unmarshal(doRequest(fs, http.MethodGet, "/?format=json", nil).Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, item := range body.Items {
+		names = append(names, item.Name)
+	}
+	if got := strings.Join(names, " "); got != "full top.txt" {
+		t.Errorf("JSON listing holds %q, want full and top.txt", got)
+	}
+}
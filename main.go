@@ -1,162 +1,1781 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"html/template"
 	"io"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/webdav"
+	"golang.org/x/time/rate"
 )
 
 type FileInfo struct {
-	Name    string
-	IsDir   bool
-	Size    int64
-	ModTime time.Time
-	URL     string
+	Name      string
+	IsDir     bool
+	Size      int64
+	ModTime   time.Time
+	URL       string
+	Thumbnail string
+	Preview   string // ?preview=true link with --preview-size
+	Letter    string // heading shown before this entry with --group-by-letter
+	Children  string // entry count of a directory with --show-child-counts
 }
 
 type DirectoryListing struct {
-	Path  string
-	Files []FileInfo
+	Path      string
+	ParentURL string
+	Favicon   string
+	Search    string
+	Type      string // ?type= group, "" for all files
+	TypeChips []typeChip
+	Sort      string
+	Desc      bool
+	Files     []FileInfo
+	FileCount int
+	DirCount  int
+	TotalSize int64
+
+	// Pagination; PrevURL and NextURL are empty on the first/last page
+	Page    int
+	Pages   int
+	PerPage int
+	PrevURL string
+	NextURL string
+
+	TimeFormat  string
+	ClientSort  bool
+	Checksums   bool
+	ChildCounts bool
+	Columns     int
+
+	// RelativeTime shows ModTime as "3 minutes ago" as of Now; Relative is
+	// an explicit ?relative= choice to carry over to other links
+	RelativeTime bool
+	Relative     string
+	Now          time.Time
+
+	// Archive is set when serving a .zip or .tar.gz, which can't be
+	// downloaded as a ZIP again
+	Archive bool
+
+	// HideSize and HideMTime leave out the Size and Modified columns
+	HideSize  bool
+	HideMTime bool
+
+	// Readme is the directory's README with --show-readme, or nil
+	Readme *Readme
+
+	// Shown of Total entries are listed when --max-listing-entries cut the
+	// listing short; both are 0 otherwise
+	Shown int
+	Total int
 }
 
+// Listings are split into pages of defaultPerPage entries unless
+// ?per_page= asks for another size, up to maxPerPage.
+const (
+	defaultPerPage = 500
+	maxPerPage     = 10000
+)
+
+var (
+	port        = flag.Int("port", 8000, "Port to serve on")
+	bind        = flag.String("bind", "", "Address to listen on, e.g. 127.0.0.1 or ::1 (default: all interfaces)")
+	folder      = flag.String("folder", "", "Folder to serve files from (required)")
+	baseURL     = flag.String("base-url", "", "Path prefix the server is mounted at behind a reverse proxy, e.g. /files")
+	tlsCert     = flag.String("tls-cert", "", "TLS certificate file (PEM); serves HTTPS together with --tls-key")
+	tlsKey      = flag.String("tls-key", "", "TLS private key file (PEM)")
+	tlsMinVer   = flag.String("tls-min-version", "1.2", "Oldest TLS version accepted with HTTPS: 1.2 or 1.3 (TLS 1.2 only offers forward-secret AEAD ciphers)")
+	enableHTTP2 = flag.Bool("http2", false, "Advertise HTTP/2 over TLS via ALPN, or serve cleartext HTTP/2 (h2c) without TLS")
+	listenFD    = flag.Int("listen-fd", -1, "Serve on this inherited listening socket instead of binding --port (systemd's LISTEN_FDS is picked up automatically)")
+	autoPort    = flag.Bool("auto-port", false, "If --port is taken, use the next free port (up to 100 further) and print it")
+	configFile  = flag.String("config", "", "Read flags from this file of name = value lines (see the genconfig command); command-line flags win")
+	checkOnly   = flag.Bool("check", false, "Validate the configuration, print the effective settings and exit without serving")
+	showQR      = flag.Bool("qr", false, "Print a QR code of the server URL (a LAN address for wildcard binds) at startup")
+	verbose     = flag.Bool("verbose", false, "Log how each request is resolved and handled, for debugging 403s and 404s")
+	quiet       = flag.Bool("quiet", false, "Suppress the informational startup banner")
+	colorMode   = flag.String("color", "auto", "Color the banner and access log: auto (only on a terminal without NO_COLOR), always or never")
+	jsonStartup = flag.Bool("json-startup", false, "Print the bound address and served path as a single JSON line on startup")
+	accessLog   = flag.Bool("access-log", false, "Log one logfmt line per request to stderr")
+	logJSON     = flag.Bool("log-json", false, "Log JSON lines to stderr: one record per request (implies --access-log) and the server's own messages")
+	healthz     = flag.Bool("healthz", false, "Answer GET and HEAD on /healthz with 200 for health checks")
+	metrics     = flag.Bool("metrics", false, "Expose Prometheus metrics (requires a build with -tags metrics)")
+	metricsPath = flag.String("metrics-path", "/metrics", "Path to serve Prometheus metrics on when --metrics is set")
+	cspPolicy   = flag.String("csp", defaultCSP, "Content-Security-Policy sent with served files, or \"off\" for none; listings and other generated pages use their own")
+	hardenSVG   = flag.Bool("harden-svg", false, "Serve SVG files as attachments with a script-blocking Content-Security-Policy")
+	writable    = flag.Bool("writable", false, "Allow uploading files with PUT")
+	webdavFlag  = flag.Bool("webdav", false, "Answer WebDAV requests for mounting as a drive (read-only unless --writable)")
+	tus         = flag.Bool("tus", false, "Accept resumable uploads with the tus protocol at /.tus (needs --writable)")
+	tusDirPath  = flag.String("tus-dir", "", "Private directory (mode 0700) for partial --tus uploads (default: under the user cache directory)")
+	writePrefix = flag.String("write-prefix", "", "Only allow writes (PUT, POST, WebDAV changes) below this path, e.g. /incoming; reads work everywhere")
+	uploadDir   = flag.String("upload-dir", "", "Store all multipart POST and tus uploads in this folder below --folder, whatever the request path (PUT keeps its path)")
+	uploadMode  = flag.String("upload-mode", "0644", "Octal permissions of uploaded files")
+	uploadDirMd = flag.String("upload-dir-mode", "0755", "Octal permissions of directories created for uploads")
+	maxUpload   = flag.String("max-upload-size", "", "Maximum upload body size when --writable is set, e.g. 100MB (default unlimited)")
+	gzipFiles   = flag.Bool("gzip", false, "Compress compressible file responses with gzip when the client accepts it")
+	brotliFiles = flag.Bool("brotli", false, "Compress compressible file responses with Brotli when the client accepts it")
+	compOrder   = flag.String("compress-order", "br,gzip", "Preferred order of the enabled encodings when a client accepts several")
+	precompress = flag.Bool("precompressed", false, "Serve file.gz / file.br sidecars next to a file when the client accepts that encoding")
+	compressMin = flag.String("compress-min-size", "1KB", "Smallest file size worth compressing with --gzip or --brotli")
+	faultRate   = flag.Float64("fault-rate", 0, "Fraction of requests (0-1) to fail on purpose, for chaos testing")
+	faultStatus = flag.Int("fault-status", http.StatusInternalServerError, "Status code returned by injected faults")
+	faultSeed   = flag.Int64("fault-seed", 0, "Seed for fault injection (default: time-based)")
+	cacheMaxAge = flag.Int("cache-max-age", 0, "Send Cache-Control: public, max-age=N (seconds) on files (default: no Cache-Control)")
+	previewSize = flag.String("preview-size", "", "Let ?preview=true show the first this many bytes of a text file, e.g. 16KB, with preview links in listings (default off)")
+	allowFollow = flag.Bool("allow-follow", false, "Let ?follow=true stream a growing file like tail -f")
+	maxDepth    = flag.Int("max-depth", -1, "Only serve directories up to this many levels below --folder; deeper requests get 403 (0: only the root; default unlimited)")
+	cleanURLs   = flag.Bool("clean-urls", false, "Serve name.html for /name when no such file or directory exists")
+	showReadme  = flag.Bool("show-readme", false, "Show a directory's README.md or README.txt below its listing")
+	renderMD    = flag.Bool("render-markdown", false, "Render .md files as HTML pages for browsers (?raw=true or /raw/ gets the source)")
+	spa         = flag.Bool("spa", false, "Serve the root index.html for unknown routes, for single-page apps")
+	noListing   = flag.Bool("no-listing", false, "Answer directory requests with 403 instead of a listing")
+	checksums   = flag.Bool("checksums", false, "Add a SHA-256 checksum link to each file in directory listings")
+	thumbnails  = flag.Bool("thumbnails", false, "Show thumbnail previews for images in directory listings")
+	thumbStore  = flag.String("thumb-cache-dir", "", "Private directory (mode 0700) to keep --thumbnails in across restarts (default: under the user cache directory)")
+	thumbLimit  = flag.String("thumb-cache-size", "", "Delete the least recently used thumbnails once the cache holds more than this, e.g. 100MB (default unlimited)")
+	hideSize    = flag.Bool("hide-size", false, "Leave file sizes out of listings (sorting by size falls back to name)")
+	hideMTime   = flag.Bool("hide-mtime", false, "Leave modification times out of listings (sorting by time falls back to name)")
+	childCount  = flag.Bool("show-child-counts", false, "Show the number of entries of each subdirectory in listings")
+	byLetter    = flag.Bool("group-by-letter", false, "Group listing entries under first-letter headings (A, B, C...)")
+	relTime     = flag.Bool("relative-time", false, "Show listing times as \"3 minutes ago\", with the exact time on hover (?relative=true|false per request)")
+	timeFormat  = flag.String("time-format", "2006-01-02 15:04", "Go time layout for listing timestamps, e.g. \"2006-01-02 15:04:05\"")
+	sortNatural = flag.Bool("sort-natural", false, "Sort listing names in natural order, so file2 comes before file10")
+	clientSort  = flag.Bool("client-sort", false, "Let the browser sort listing columns with a small embedded script")
+	hideEmpty   = flag.Bool("hide-empty-dirs", false, "Omit subdirectories without any entries from listings")
+	viewSource  = flag.Bool("view-source", false, "Show source files (.go, .py, .sh, ...) as text in the browser instead of downloading them; ?download=1 still downloads")
+	defaultMime = flag.String("default-mime", "", "MIME type for unknown extensions, e.g. text/plain (default application/octet-stream)")
+	mimeFile    = flag.String("mime-file", "", "Apache-style mime.types file with extra MIME types")
+	favicon     = flag.String("favicon", "", "Icon file answered for /favicon.ico when the folder has none (default: a built-in icon)")
+	errorDir    = flag.String("error-dir", "", "Directory with custom error pages named <status>.html, e.g. 404.html")
+	errorTmpl   = flag.String("error-template", "", "html/template file for error pages; gets .Status, .StatusText and .Message")
+	bundle      = flag.Bool("bundle", false, "Serve /.bundle?files=a.js,b.js with the listed files concatenated")
+	blockMaps   = flag.Bool("block-sourcemaps", false, "Answer requests for .map source maps with 404 unless the client is in --sourcemap-allow")
+	webhookKey  = flag.String("webhook-key", "", "Shared secret required as ?key= for paths under --webhook-prefix")
+	serverName  = flag.String("server-name", "", "Send this Server header on every response; an empty value sends none, even one given with --header")
+	canonical   = flag.String("canonical-host", "", "Redirect requests for any other Host to this host (301)")
+	authMode    = flag.String("auth-mode", "basic", "How --acl-file users log in: basic (the browser's dialog) or form (a login page and session cookie; API clients still use basic auth)")
+	authSecret  = flag.String("auth-secret", "", "Key signing --auth-mode=form sessions, so they survive restarts and work across instances (default: random per run)")
+	maxListing  = flag.Int("max-listing-entries", 0, "List at most this many entries of a directory (after sorting) with a notice about the rest (default unlimited)")
+	listCache   = flag.Bool("cache-listings", false, "Keep generated directory listings in memory and reuse them while the directory's entries are unchanged")
+	mediaPlayer = flag.Bool("media-player", false, "Show audio and video files to browsers in an HTML5 player page; other clients get the file")
+	allowNoExt  = flag.Bool("allow-no-ext", false, "With --allow-ext, also serve files without an extension")
+	sumTrailer  = flag.Bool("checksum-trailer", false, "Send the SHA-256 of whole-file downloads in an X-Content-SHA256 trailer to clients sending TE: trailers")
+	maxOpen     = flag.Int("max-open-files", 0, "Keep at most this many served files open at once; requests past it get 503 with Retry-After (default unlimited)")
+	shareSecret = flag.String("share-secret", "", "Only answer requests with a valid ?exp=&sig= link signed with this secret (see --sign-url)")
+	signURL     = flag.String("sign-url", "", "Print a --share-secret link to this path, expiring after --share-ttl, and exit")
+	shareTTL    = flag.Duration("share-ttl", 24*time.Hour, "How long links from --sign-url stay valid")
+	hotlinkKey  = flag.String("hotlink-secret", "", "Secret signing --sign-hotlink tokens, which exempt single files from --hotlink-allow")
+	signHotlink = flag.String("sign-hotlink", "", "Print a link to this file that --hotlink-allow serves without a Referer, and exit")
+	profile     = flag.Bool("profile", false, "Serve net/http/pprof CPU, heap and goroutine profiles on 127.0.0.1:--profile-port, never on the public address")
+	profilePort = flag.Int("profile-port", 6060, "Localhost port for --profile")
+	watch       = flag.Bool("watch", false, "Watch the folder for changes and drop cached file contents, checksums, rendered Markdown and entry counts right away")
+	aclFilePath = flag.String("acl-file", "", "JSON file of per-path rules allowing users (basic auth) or client networks; reloaded when it changes")
+	trustProxy  = flag.Bool("trust-proxy", false, "Take the client address from X-Forwarded-For (only behind a trusted reverse proxy)")
+	maxConns    = flag.Int("max-connections", 0, "Serve at most this many requests at once; others get 503 (default unlimited)")
+	connWait    = flag.Duration("max-connections-wait", 0, "How long a request over --max-connections may wait for a free slot before the 503 (default: no waiting)")
+	sendfile    = flag.String("sendfile-header", "", "Let the reverse proxy send file bodies: answer with this header (X-Accel-Redirect or X-Sendfile) and an empty body")
+	sendfileDir = flag.String("sendfile-prefix", "/internal", "Internal nginx location that --sendfile-header X-Accel-Redirect paths are placed under")
+	copyBuffer  = flag.String("copy-buffer", "", "Copy file bodies through buffers of this size, e.g. 256KB, instead of net/http's 32KB (default: let the kernel copy when it can)")
+	maxRate     = flag.String("max-total-rate", "", "Cap the combined download rate of all connections, in bytes per second, e.g. 10MB (default unlimited)")
+	rateBPS     = flag.String("rate-bps", "", "Cap the rate of each download, in bytes per second, e.g. 1MB; within --max-total-rate if both are set (default unlimited)")
+)
+
+// Server timeouts
+var (
+	readTimeout   = flag.Duration("read-timeout", 15*time.Second, "Maximum time to read a request, body included (0 disables)")
+	headerTimeout = flag.Duration("read-header-timeout", 5*time.Second, "Maximum time to read request headers, against slowloris (0 falls back to --read-timeout)")
+	writeTimeout  = flag.Duration("write-timeout", 0, "Maximum time to write a response (0 disables; a limit also caps download duration)")
+	idleTimeout   = flag.Duration("idle-timeout", 120*time.Second, "How long idle keep-alive connections stay open (0 disables)")
+
+	requestTimeout = flag.Duration("request-timeout", 0, "Answer 503 when building a listing, checksum, thumbnail or ZIP takes longer (0 disables; file downloads are exempt)")
+)
+
+// Automatic Let's Encrypt certificates
+var (
+	autocertDomain = flag.String("autocert-domain", "", "Get Let's Encrypt certificates for this domain (comma-separated for several); serves HTTPS on :443 and ACME challenges on :80")
+	autocertCache  = flag.String("autocert-cache", "", "Directory to keep --autocert-domain certificates in (default: under the user cache directory)")
+)
+
+// In-memory file cache
+var (
+	cacheSize    = flag.String("cache-size", "", "Keep up to this many bytes of small files in an in-memory LRU cache, e.g. 64MB (default off)")
+	cacheFileMax = flag.String("cache-max-file-size", "1MB", "Largest file kept in the --cache-size cache")
+)
+
+// Repeatable flags, registered in init.
 var (
-	port   = flag.Int("port", 8000, "Port to serve on")
-	folder = flag.String("folder", "", "Folder to serve files from (required)")
+	rewriteFlags  stringList
+	cacheExtFlags stringList
+	allowFlags    stringList
+	denyFlags     stringList
+	webhookFlags  stringList
+	mimeFlags     stringList
+	mapAllowFlags stringList
+	hideFlags     stringList
+	headerFlags   stringList
+	overlayFlags  stringList
+	sendfileNets  stringList
+	allowExtFlags stringList
+	hotlinkHosts  stringList
 )
 
+func init() {
+	flag.Var(&rewriteFlags, "rewrite", "Rewrite request paths as from=to before resolution; a from starting with ^ is a regexp (repeatable)")
+	flag.Var(&allowFlags, "allow", "Only allow clients from this CIDR range (repeatable)")
+	flag.Var(&denyFlags, "deny", "Reject clients from this CIDR range (repeatable)")
+	flag.Var(&mapAllowFlags, "sourcemap-allow", "CIDR range still allowed to fetch source maps with --block-sourcemaps (repeatable)")
+	flag.Var(&webhookFlags, "webhook-prefix", "Path prefix that requires the --webhook-key secret, e.g. /hooks/ (repeatable)")
+	flag.Var(&mimeFlags, "mime", "MIME type override as .ext=type, e.g. .glb=model/gltf-binary (repeatable)")
+	flag.Var(&hideFlags, "hide", "Glob of files to hide from listings and answer with 404, e.g. *.bak or node_modules (repeatable)")
+	flag.Var(&headerFlags, "header", "Extra response header as \"Name: Value\", e.g. \"X-Frame-Options: DENY\" (repeatable)")
+	flag.Var(&sendfileNets, "sendfile-proxy", "CIDR range of the proxy trusted with --sendfile-header (repeatable; default loopback)")
+	flag.Var(&overlayFlags, "overlay", "Folder merged over --folder into one tree; later overlays win name collisions (repeatable)")
+	flag.Var(&allowExtFlags, "allow-ext", "Only serve and list files with this extension, e.g. jpg or .pdf (repeatable or comma-separated; directories stay navigable)")
+	flag.Var(&hotlinkHosts, "hotlink-allow", "Only serve files to pages on this host or the server's own, by Referer; requests without one need a --sign-hotlink token (repeatable or comma-separated)")
+	flag.StringVar(maxRate, "total-rate-bps", "", "Alias of --max-total-rate")
+	flag.Var(&cacheExtFlags, "cache-ext", "Per-extension max-age overrides as ext=seconds, comma-separated, e.g. html=0,js=3600 (repeatable)")
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// startupInfo is the machine-readable form of the startup banner.
+type startupInfo struct {
+	Address string   `json:"address"`
+	URL     string   `json:"url"`
+	URLs    []string `json:"urls"`
+	Path    string   `json:"path"`
+}
+
 func main() {
-	flag.Parse()
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if command, ok := commands[args[0]]; ok {
+			command(args[1:])
+			return
+		}
+	}
+	serveCommand(args)
+}
 
+// serve validates the parsed flags and runs the server until it fails.
+func serve() {
 	if *folder == "" {
-		fmt.Println("Error: --folder is required")
-		os.Exit(1)
+		fatalf("Error: --folder is required")
+	}
+
+	if *metrics && !metricsSupported {
+		fatalf("Error: --metrics requires a binary built with -tags metrics")
 	}
 
 	// Validate folder path
 	servePath, err := filepath.Abs(*folder)
 	if err != nil {
-		fmt.Printf("Error: Invalid folder path: %v\n", err)
-		os.Exit(1)
+		fatalf("Error: Invalid folder path: %v", err)
 	}
 
 	// Check if folder exists
-	if _, err := os.Stat(servePath); os.IsNotExist(err) {
-		fmt.Printf("Error: Folder '%s' does not exist\n", servePath)
-		os.Exit(1)
+	rootInfo, err := os.Stat(servePath)
+	if os.IsNotExist(err) {
+		fatalf("Error: Folder '%s' does not exist", servePath)
+	}
+	if err != nil {
+		fatalf("Error: Cannot access '%s': %v", servePath, err)
+	}
+	// A regular file instead of a folder is shared on its own
+	singleFile := rootInfo.Mode().IsRegular()
+	if !singleFile && !rootInfo.IsDir() {
+		fatalf("Error: '%s' is neither a folder nor a regular file (%s)", servePath, describeFileType(rootInfo.Mode()))
+	}
+	// A .zip or .tar.gz stands in for a folder instead
+	var archive *archiveTree
+	if singleFile && isArchivePath(servePath) {
+		singleFile = false
+		if archive, err = openArchive(servePath); err != nil {
+			fatalf("Error: Cannot read archive '%s': %v", servePath, err)
+		}
+		if *writable || *webdavFlag || len(overlayFlags) > 0 {
+			fatalf("Error: An archive is served read-only; --writable, --webdav and --overlay need a folder")
+		}
+	} else if err := checkReadable(servePath, singleFile); err != nil {
+		// Fail now rather than with a 500 on every request
+		fatalf("Error: Cannot read '%s': %v", servePath, err)
+	}
+
+	var maxUploadSize int64
+	if *maxUpload != "" {
+		maxUploadSize, err = parseByteSize(*maxUpload)
+		if err != nil {
+			fatalf("Error: --max-upload-size: %v", err)
+		}
+	}
+
+	fileMode, err := parseFileMode(*uploadMode)
+	if err != nil {
+		fatalf("Error: --upload-mode: %v", err)
+	}
+	dirMode, err := parseFileMode(*uploadDirMd)
+	if err != nil {
+		fatalf("Error: --upload-dir-mode: %v", err)
+	}
+
+	var uploadPath string
+	if *uploadDir != "" {
+		uploadPath = filepath.Join(servePath, filepath.FromSlash(strings.Trim(*uploadDir, "/")))
+		if rel, err := filepath.Rel(servePath, uploadPath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			fatalf("Error: --upload-dir must be inside --folder")
+		}
+	}
+
+	var writeRoot string
+	if *writePrefix != "" {
+		writeRoot = "/" + strings.Trim(path.Clean("/"+filepath.ToSlash(*writePrefix)), "/")
+		if !isWithin(filepath.Join(servePath, filepath.FromSlash(writeRoot)), servePath) || strings.Contains(*writePrefix, "..") {
+			fatalf("Error: --write-prefix must be inside --folder")
+		}
+		if uploadPath != "" && !hasPathPrefix("/"+filepath.ToSlash(strings.Trim(*uploadDir, "/")), writeRoot) {
+			fatalf("Error: --upload-dir must be inside --write-prefix")
+		}
 	}
 
-	fmt.Printf("Serving files from: %s\n", servePath)
-	fmt.Printf("Server running on: http://localhost:%d\n", *port)
-	fmt.Println("Press Ctrl+C to stop the server")
+	compressMinSize, err := parseByteSize(*compressMin)
+	if err != nil {
+		fatalf("Error: --compress-min-size: %v", err)
+	}
+	encodings, err := parseCompressOrder(*compOrder, map[string]bool{"gzip": *gzipFiles, "br": *brotliFiles})
+	if err != nil {
+		fatalf("Error: --compress-order: %v", err)
+	}
+	var sidecars []string
+	if *precompress {
+		sidecars, _ = parseCompressOrder(*compOrder, map[string]bool{"gzip": true, "br": true})
+	}
+
+	if *faultRate < 0 || *faultRate > 1 {
+		fatalf("Error: --fault-rate must be between 0 and 1")
+	}
+	if *faultStatus < 100 || *faultStatus > 599 {
+		fatalf("Error: --fault-status must be a valid HTTP status code")
+	}
+
+	if *cacheMaxAge < 0 {
+		fatalf("Error: --cache-max-age must not be negative")
+	}
+	cacheMaxAgeByExt, err := parseCacheExt(cacheExtFlags)
+	if err != nil {
+		fatalf("Error: --cache-ext: %v", err)
+	}
+
+	var totalLimiter *rate.Limiter
+	if *maxRate != "" {
+		bytesPerSecond, err := parseByteSize(*maxRate)
+		if err != nil || bytesPerSecond <= 0 {
+			fatalf("Error: --max-total-rate: invalid rate %q", *maxRate)
+		}
+		totalLimiter = newByteLimiter(bytesPerSecond)
+	}
+	var connRate int64
+	if *rateBPS != "" {
+		if connRate, err = parseByteSize(*rateBPS); err != nil || connRate <= 0 {
+			fatalf("Error: --rate-bps: invalid rate %q", *rateBPS)
+		}
+	}
+
+	fileCSP := *cspPolicy
+	if fileCSP == "off" {
+		fileCSP = ""
+	}
+
+	var previewBytes int64
+	if *previewSize != "" {
+		previewBytes, err = parseByteSize(*previewSize)
+		if err != nil || previewBytes <= 0 {
+			fatalf("Error: --preview-size: invalid size %q", *previewSize)
+		}
+	}
+
+	var copyBuffers *sync.Pool
+	if *copyBuffer != "" {
+		size, err := parseByteSize(*copyBuffer)
+		if err != nil || size <= 0 || size > maxCopyBuffer {
+			fatalf("Error: --copy-buffer: invalid size %q (1 byte to 64MB)", *copyBuffer)
+		}
+		copyBuffers = newCopyBuffers(int(size))
+	}
+
+	var openSlots chan struct{}
+	if *maxOpen < 0 {
+		fatalf("Error: --max-open-files: must not be negative")
+	} else if *maxOpen > 0 {
+		openSlots = make(chan struct{}, *maxOpen)
+	}
+
+	var listings *listingCache
+	if *listCache {
+		listings = &listingCache{}
+	}
+	var files *fileCache
+	if *cacheSize != "" {
+		maxBytes, err := parseByteSize(*cacheSize)
+		if err != nil || maxBytes <= 0 {
+			fatalf("Error: --cache-size: invalid size %q", *cacheSize)
+		}
+		maxFileBytes, err := parseByteSize(*cacheFileMax)
+		if err != nil {
+			fatalf("Error: --cache-max-file-size: %v", err)
+		}
+		files = newFileCache(maxBytes, maxFileBytes)
+	}
+
+	allowNets, err := parseCIDRs(allowFlags)
+	if err != nil {
+		fatalf("Error: --allow: %v", err)
+	}
+	denyNets, err := parseCIDRs(denyFlags)
+	if err != nil {
+		fatalf("Error: --deny: %v", err)
+	}
+
+	extraHeaders, err := parseHeaders(headerFlags)
+	if err != nil {
+		fatalf("Error: --header: %v", err)
+	}
+
+	overlays, err := parseOverlays(overlayFlags, servePath)
+	if err != nil {
+		fatalf("Error: --overlay: %v", err)
+	}
+	if len(overlays) > 0 && singleFile {
+		fatalf("Error: --overlay needs --folder to be a folder")
+	}
+	if len(overlays) > 0 && *webdavFlag {
+		// WebDAV works on the one folder and would show and change it
+		// without the overlays GET sees
+		fatalf("Error: --webdav can't be combined with --overlay")
+	}
+
+	hidePatterns, err := parseHidePatterns(hideFlags)
+	if err != nil {
+		fatalf("Error: --hide: %v", err)
+	}
+	allowExts, err := parseAllowExts(allowExtFlags)
+	if err != nil {
+		fatalf("Error: --allow-ext: %v", err)
+	}
+	if *allowNoExt && allowExts == nil {
+		fatalf("Error: --allow-no-ext only applies with --allow-ext")
+	}
+
+	if *tus && !*writable {
+		fatalf("Error: --tus requires --writable")
+	}
+	thumbDir := *thumbStore
+	var thumbs *thumbCache
+	if *thumbnails {
+		if thumbDir == "" {
+			if thumbDir, err = stateDir("thumbs", servePath); err != nil {
+				fatalf("Error: --thumb-cache-dir: %v", err)
+			}
+		}
+		if err := privateDir(thumbDir); err != nil {
+			fatalf("Error: --thumb-cache-dir: %v", err)
+		}
+		if *thumbLimit != "" {
+			maxBytes, err := parseByteSize(*thumbLimit)
+			if err != nil || maxBytes <= 0 {
+				fatalf("Error: --thumb-cache-size: invalid size %q", *thumbLimit)
+			}
+			if thumbs, err = loadThumbCache(thumbDir, maxBytes); err != nil {
+				fatalf("Error: --thumb-cache-dir: %v", err)
+			}
+		}
+	}
+	tusDir := *tusDirPath
+	if *tus {
+		if tusDir == "" {
+			if tusDir, err = stateDir("tus", servePath); err != nil {
+				fatalf("Error: --tus-dir: %v", err)
+			}
+		}
+		if err := privateDir(tusDir); err != nil {
+			fatalf("Error: --tus-dir: %v", err)
+		}
+	}
+
+	if len(webhookFlags) > 0 && *webhookKey == "" {
+		fatalf("Error: --webhook-prefix requires --webhook-key")
+	}
+
+	bannerColor, err := useColor(*colorMode, os.Stdout)
+	if err != nil {
+		fatalf("Error: --color: %v", err)
+	}
+	logColor, _ := useColor(*colorMode, os.Stderr)
+
+	var jsonLog *slog.Logger
+	if *logJSON {
+		jsonLog = newJSONLogger()
+		// The log package's output goes through it too from here on
+		slog.SetDefault(jsonLog)
+	}
+
+	if *favicon != "" {
+		if err := checkFavicon(*favicon); err != nil {
+			fatalf("Error: --favicon: %v", err)
+		}
+	}
+
+	if *defaultMime != "" {
+		if err := setDefaultMime(*defaultMime); err != nil {
+			fatalf("Error: --default-mime: %v", err)
+		}
+	}
+	// --mime flags are applied last so they win over the file
+	if *mimeFile != "" {
+		if err := loadMimeFile(*mimeFile); err != nil {
+			fatalf("Error: --mime-file: %v", err)
+		}
+	}
+	for _, value := range mimeFlags {
+		if err := addMimeOverride(value); err != nil {
+			fatalf("Error: --mime: %v", err)
+		}
+	}
+
+	var errorTemplate *template.Template
+	if *errorTmpl != "" {
+		errorTemplate, err = loadErrorTemplate(*errorTmpl)
+		if err != nil {
+			fatalf("Error: --error-template: %v", err)
+		}
+	}
+
+	sourceMapNets, err := parseCIDRs(mapAllowFlags)
+	if err != nil {
+		fatalf("Error: --sourcemap-allow: %v", err)
+	}
+
+	var sendfileHeader string
+	var sendfileProxies []*net.IPNet
+	if *sendfile != "" {
+		if sendfileHeader, err = parseSendfileHeader(*sendfile); err != nil {
+			fatalf("Error: --sendfile-header: %v", err)
+		}
+		if len(sendfileNets) == 0 {
+			sendfileNets = defaultSendfileProxies
+		}
+		if sendfileProxies, err = parseCIDRs(sendfileNets); err != nil {
+			fatalf("Error: --sendfile-proxy: %v", err)
+		}
+	}
+
+	var rewrites []rewriteRule
+	for _, value := range rewriteFlags {
+		rule, err := parseRewriteRule(value)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		rewrites = append(rewrites, rule)
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fatalf("Error: --tls-cert and --tls-key must be given together")
+	}
+	tlsMinVersion, err := parseTLSVersion(*tlsMinVer)
+	if err != nil {
+		fatalf("Error: --tls-min-version: %v", err)
+	}
+	useTLS := *tlsCert != ""
+	scheme := "http"
+	if useTLS {
+		if _, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey); err != nil {
+			fatalf("Error: Invalid TLS certificate: %v", err)
+		}
+		scheme = "https"
+	}
+
+	var certManager *autocert.Manager
+	var domains []string
+	if *autocertDomain != "" {
+		if useTLS {
+			fatalf("Error: --autocert-domain cannot be combined with --tls-cert")
+		}
+		domains, err = parseDomains(*autocertDomain)
+		if err != nil {
+			fatalf("Error: --autocert-domain: %v", err)
+		}
+		certManager = newCertManager(domains, *autocertCache)
+		useTLS, scheme, *port = true, "https", 443
+	}
+
+	addr := net.JoinHostPort(*bind, strconv.Itoa(*port))
+	urls := reachableURLs(scheme, *bind, *port)
+	if certManager != nil {
+		// Certificates are only valid for the domains, not for bare IPs
+		urls = urls[:0]
+		for _, domain := range domains {
+			urls = append(urls, "https://"+domain)
+		}
+	}
+
+	var aclPath string
+	var acl *accessList
+	if *aclFilePath != "" {
+		if aclPath, err = filepath.Abs(*aclFilePath); err != nil {
+			fatalf("Error: --acl-file: %v", err)
+		}
+		if acl, err = loadACL(aclPath); err != nil {
+			fatalf("Error: --acl-file: %v", err)
+		}
+	}
+
+	var sessionSecret []byte
+	switch *authMode {
+	case "basic":
+	case "form":
+		if *aclFilePath == "" {
+			fatalf("Error: --auth-mode=form needs --acl-file for its users")
+		}
+		if sessionSecret, err = newSessionSecret(*authSecret); err != nil {
+			fatalf("Error: --auth-secret: %v", err)
+		}
+	default:
+		fatalf("Error: --auth-mode: want basic or form, got %q", *authMode)
+	}
+
+	if *checkOnly {
+		printEffectiveConfig(servePath, addr, urls[0])
+		return
+	}
+	if *signURL != "" {
+		if *shareSecret == "" {
+			fatalf("Error: --sign-url needs --share-secret")
+		}
+		name := *signURL
+		if singleFile {
+			// A served file is the root, whatever the request path
+			name = "/"
+		}
+		fmt.Println(urls[0] + normalizeBaseURL(*baseURL) + signedPath([]byte(*shareSecret), name, *shareTTL))
+		return
+	}
+	if *signHotlink != "" {
+		if *hotlinkKey == "" {
+			fatalf("Error: --sign-hotlink needs --hotlink-secret")
+		}
+		name := *signHotlink
+		if singleFile {
+			name = "/"
+		}
+		fmt.Println(urls[0] + normalizeBaseURL(*baseURL) + hotlinkPath([]byte(*hotlinkKey), name))
+		return
+	}
+
+	listener, err := inheritedListener(*listenFD)
+	if err != nil {
+		fatalf("Error: --listen-fd: %v", err)
+	}
+	if listener != nil {
+		// The socket was bound by whoever passed it, so report its address
+		addr = listener.Addr().String()
+		if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok {
+			*bind, *port = tcpAddr.IP.String(), tcpAddr.Port
+			urls = reachableURLs(scheme, *bind, *port)
+		} else {
+			urls = []string{listener.Addr().Network() + ":" + addr}
+		}
+	} else {
+		listener, err = listen(*bind, *port, *autoPort)
+		if isAddrInUse(err) {
+			fatalf("Error: Port %d is already in use. Pick another one with --port, or pass --auto-port to use the next free one.", *port)
+		}
+		if err != nil {
+			fatalf("Error: Cannot listen on %s: %v", addr, err)
+		}
+		if actual := listener.Addr().(*net.TCPAddr).Port; actual != *port {
+			// --auto-port moved on, or --port 0 let the kernel choose
+			*port = actual
+			addr = net.JoinHostPort(*bind, strconv.Itoa(*port))
+			urls = reachableURLs(scheme, *bind, *port)
+		}
+	}
+
+	switch {
+	case *jsonStartup:
+		line, _ := json.Marshal(startupInfo{Address: addr, URL: urls[0], URLs: urls, Path: servePath})
+		fmt.Println(string(line))
+	case !*quiet:
+		if singleFile {
+			fmt.Printf("Serving file: %s\n", colorize(bannerColor, ansiBold, servePath))
+		} else {
+			fmt.Printf("Serving files from: %s\n", colorize(bannerColor, ansiBold, servePath))
+		}
+		fmt.Printf("Server running on: %s\n", colorize(bannerColor, ansiCyan, urls[0]))
+		for _, url := range urls[1:] {
+			fmt.Printf("                   %s\n", colorize(bannerColor, ansiCyan, url))
+		}
+		fmt.Println(colorize(bannerColor, ansiDim, "Press Ctrl+C to stop the server"))
+	}
+	if *showQR && !*jsonStartup {
+		// The first URL is localhost for wildcard binds, which is useless
+		// on a phone; the next one is a LAN address
+		qrURL := urls[0]
+		if len(urls) > 1 && isUnspecifiedHost(*bind) {
+			qrURL = urls[1]
+		}
+		if err := printQR(os.Stdout, qrURL); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Cannot draw QR code: %v\n", err)
+		}
+	}
 
 	// Create HTTP handler
-	handler := &FileServer{servePath: servePath}
+	fileServer := &FileServer{
+		servePath: servePath,
+		overlays:  overlays,
+		archive:   archive,
+		hardenSVG: *hardenSVG,
+		csp:       fileCSP,
+		rewrites:  rewrites,
+		baseURL:   normalizeBaseURL(*baseURL),
+
+		singleFile: singleFile,
+		verbose:    *verbose,
+
+		writable:      *writable,
+		maxUploadSize: maxUploadSize,
+		uploadDir:     uploadPath,
+		writePrefix:   writeRoot,
+		tus:           *tus,
+		tusDir:        tusDir,
+		fileMode:      fileMode,
+		dirMode:       dirMode,
+
+		requestTimeout: *requestTimeout,
+
+		encodings:       encodings,
+		compressMinSize: compressMinSize,
+		sidecars:        sidecars,
+
+		cacheMaxAge:      *cacheMaxAge,
+		cacheMaxAgeByExt: cacheMaxAgeByExt,
+
+		totalLimiter:  totalLimiter,
+		connRate:      connRate,
+		fileCache:     files,
+		listingPages:  listings,
+		hideEmptyDirs: *hideEmpty,
+		hidePatterns:  hidePatterns,
+		allowExts:     allowExts,
+		allowNoExt:    *allowNoExt,
+		clientSort:    *clientSort,
+		sortNatural:   *sortNatural,
+		timeFormat:    *timeFormat,
+		relativeTime:  *relTime,
+		noListing:     *noListing,
+		maxDepth:      *maxDepth,
+		maxListing:    *maxListing,
+		mediaPlayer:   *mediaPlayer,
+		cleanURLs:     *cleanURLs,
+		spa:           *spa,
+		favicon:       *favicon,
+		viewSource:    *viewSource,
+
+		renderMarkdown: *renderMD,
+		showReadme:     *showReadme,
+
+		allowFollow:   *allowFollow,
+		previewSize:   previewBytes,
+		thumbnails:    *thumbnails,
+		showChecksums: *checksums,
+		sumTrailer:    *sumTrailer,
+		groupByLetter: *byLetter,
+		childCounts:   *childCount,
+		hideSize:      *hideSize,
+		hideMTime:     *hideMTime,
+		thumbDir:      thumbDir,
+		thumbs:        thumbs,
+
+		allowNets:  allowNets,
+		denyNets:   denyNets,
+		trustProxy: *trustProxy,
+
+		canonicalHost: *canonical,
+
+		errorDir:      *errorDir,
+		errorTemplate: errorTemplate,
+
+		webhookKey:      *webhookKey,
+		webhookPrefixes: webhookFlags,
+
+		blockSourceMaps: *blockMaps,
+		sourceMapNets:   sourceMapNets,
+
+		bundle: *bundle,
 
-	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", *port),
-		Handler: handler,
+		sendfileHeader: sendfileHeader,
+		sendfilePrefix: *sendfileDir,
+		sendfileNets:   sendfileProxies,
+
+		copyBuffers: copyBuffers,
+
+		openSlots: openSlots,
+	}
+	if acl != nil {
+		fileServer.acl.Store(acl)
+		if err := watchACL(aclPath, &fileServer.acl); err != nil {
+			fatalf("Error: --acl-file: %v", err)
+		}
+	}
+	if *shareSecret != "" {
+		fileServer.shareSecret = []byte(*shareSecret)
+	}
+	fileServer.hotlinkHosts = parseHotlinkHosts(hotlinkHosts)
+	if *hotlinkKey != "" {
+		fileServer.hotlinkSecret = []byte(*hotlinkKey)
+	}
+	if *authMode == "form" {
+		fileServer.formAuth = true
+		fileServer.sessionSecret = sessionSecret
+	}
+	if *watch {
+		if archive != nil || singleFile {
+			log.Printf("Warning: --watch only applies to folders")
+		} else if err := watchTree(fileServer); err != nil {
+			log.Printf("Warning: --watch: %v; caches rely on Stat checks", err)
+		}
+	}
+	if *webdavFlag {
+		fileServer.webdav = newWebDAVHandler(fileServer)
+	}
+	if *metrics {
+		fileServer.metrics = newMetricsHandler()
+		fileServer.metricsPath = "/" + strings.Trim(*metricsPath, "/")
+	}
+	var handler http.Handler = fileServer
+	if *faultRate > 0 {
+		seed := *faultSeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		handler = withFaults(handler, *faultRate, *faultStatus, seed)
+	}
+	handler = withMaintenance(handler, fileServer)
+	watchMaintenanceSignal()
+	if *maxConns > 0 {
+		handler = withConcurrencyLimit(handler, *maxConns, *connWait)
 	}
+	if *healthz {
+		handler = withHealth(handler)
+	}
+	if *metrics {
+		handler = withMetrics(handler)
+	}
+	if *accessLog || jsonLog != nil {
+		handler = withAccessLog(handler, ipEnricher, *trustProxy, logColor, jsonLog)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "server-name" {
+			handler = withServerName(handler, *serverName)
+		}
+	})
+	if len(extraHeaders) > 0 {
+		handler = withHeaders(handler, extraHeaders)
+	}
+	handler = withRequestID(handler)
+
+	if *profile {
+		url, err := startProfiling(*profilePort)
+		if err != nil {
+			fatalf("Error: --profile: %v", err)
+		}
+		log.Printf("Profiling at %s (localhost only)", url)
+	}
+
+	if *enableHTTP2 && !useTLS {
+		// Cleartext HTTP/2 needs the h2c upgrade/prior-knowledge handler
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	server := newHTTPServer(addr, handler)
+
+	if !useTLS {
+		log.Fatal(server.Serve(listener))
+	}
+	if certManager != nil {
+		server.TLSConfig = certManager.TLSConfig()
+		go serveACMEChallenges(certManager, *bind)
+	}
+	server.TLSConfig = hardenTLS(server.TLSConfig, tlsMinVersion)
+	if *enableHTTP2 {
+		// Go enables h2 for TLS by default; configuring it explicitly makes
+		// sure ALPN advertises h2 even if the default is switched off.
+		if err := http2.ConfigureServer(server, &http2.Server{}); err != nil {
+			fatalf("Error: Cannot enable HTTP/2: %v", err)
+		}
+	}
+	log.Fatal(server.ServeTLS(listener, *tlsCert, *tlsKey))
+}
+
+// newHTTPServer returns the server for addr and handler, with the
+// connection timeouts from the --*-timeout flags.
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       *readTimeout,
+		ReadHeaderTimeout: *headerTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+	}
+}
+
+// secretFlags are never printed by --check.
+var secretFlags = map[string]bool{
+	"webhook-key":    true,
+	"auth-secret":    true,
+	"share-secret":   true,
+	"hotlink-secret": true,
+}
+
+// printEffectiveConfig is the --check report: every flag that differs from
+// its default (after environment defaults are applied), plus the resolved
+// serve path and address.
+func printEffectiveConfig(servePath, addr, url string) {
+	fmt.Println("Configuration OK")
+	fmt.Printf("  serve path: %s\n", servePath)
+	fmt.Printf("  address:    %s (%s)\n", addr, url)
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "check" || f.Value.String() == f.DefValue {
+			return
+		}
+		value := f.Value.String()
+		if secretFlags[f.Name] {
+			value = "(set)"
+		}
+		fmt.Printf("  --%s=%s\n", f.Name, value)
+	})
+}
+
+// checkReadable makes sure the serve root can actually be read: a folder
+// must be listable and a single file openable.
+func checkReadable(path string, singleFile bool) error {
+	if singleFile {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}
+	_, err := os.ReadDir(path)
+	return err
+}
+
+// describeFileType names the kind of a non-regular, non-directory file.
+func describeFileType(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSocket != 0:
+		return "a socket"
+	case mode&os.ModeNamedPipe != 0:
+		return "a named pipe"
+	case mode&os.ModeCharDevice != 0:
+		return "a character device"
+	case mode&os.ModeDevice != 0:
+		return "a device"
+	default:
+		return "mode " + mode.String()
+	}
+}
+
+// applyEnvDefaults fills --port and --folder from the environment when they
+// were not given on the command line or in --config. SHS_PORT/SHS_FOLDER
+// take precedence over the generic PORT/FOLDER.
+func applyEnvDefaults() {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if !set["port"] {
+		if name, value := lookupEnv("SHS_PORT", "PORT"); value != "" {
+			p, err := strconv.Atoi(value)
+			if err != nil || p < 0 || p > 65535 {
+				fatalf("Error: %s must be a port number, got %q", name, value)
+			}
+			*port = p
+		}
+	}
+	if !set["folder"] {
+		if _, value := lookupEnv("SHS_FOLDER", "FOLDER"); value != "" {
+			*folder = value
+		}
+	}
+}
+
+// lookupEnv returns the first of names that is set to a non-empty value.
+func lookupEnv(names ...string) (string, string) {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return name, value
+		}
+	}
+	return "", ""
+}
 
-	log.Fatal(server.ListenAndServe())
+// fatalf prints an error message to stderr and exits with a non-zero status.
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
 }
 
 type FileServer struct {
 	servePath string
+	overlays  []string     // --overlay roots over servePath, lowest precedence first
+	archive   *archiveTree // set when servePath is a .zip or .tar.gz served as a tree
+	hardenSVG bool
+	csp       string // Content-Security-Policy of served files, "" for none
+	rewrites  []rewriteRule
+	baseURL   string // "" or a prefix like "/files", without trailing slash
+
+	// singleFile is set when servePath is a regular file, not a folder
+	singleFile bool
+	verbose    bool
+
+	writable      bool
+	maxUploadSize int64
+	uploadDir     string          // absolute; "" to upload into the request's directory
+	writePrefix   string          // "" or a root-relative "/dir" that writes must stay in
+	webdav        *webdav.Handler // nil unless --webdav
+	metrics       http.Handler    // nil unless --metrics
+	metricsPath   string          // below --base-url
+	uploadLocks   sync.Map        // target path -> *sync.Mutex, see lockUploadPath
+	fileMode      os.FileMode     // --upload-mode
+	dirMode       os.FileMode     // --upload-dir-mode
+	tus           bool
+	tusDir        string // partial tus uploads, see tusUpload
+
+	requestTimeout time.Duration // for handlers run through timed
+
+	encodings       []string
+	compressMinSize int64
+	sidecars        []string // --precompressed codings, in order of preference
+
+	cacheMaxAge      int
+	cacheMaxAgeByExt map[string]int
+
+	totalLimiter  *rate.Limiter
+	connRate      int64 // --rate-bps, bytes per second for each response; 0 for no limit
+	hideEmptyDirs bool
+	hidePatterns  []string
+	allowExts     []string // --allow-ext suffixes like ".jpg", nil to serve every file
+	allowNoExt    bool
+	fileCache     *fileCache // nil unless --cache-size
+	clientSort    bool
+	sortNatural   bool
+	timeFormat    string
+	relativeTime  bool
+	noListing     bool
+	maxDepth      int
+	maxListing    int // --max-listing-entries, 0 for no cap
+	cleanURLs     bool
+	spa           bool
+	favicon       string // --favicon file served when the root has no favicon.ico
+	viewSource    bool
+	mediaPlayer   bool
+	previewSize   int64 // --preview-size, 0 when previews are off
+
+	renderMarkdown bool
+	markdownPages  markdownCache
+	showReadme     bool
+	readmePages    markdownCache
+
+	allowFollow   bool
+	thumbnails    bool
+	thumbDir      string
+	thumbs        *thumbCache // --thumb-cache-size eviction, nil without a limit
+	showChecksums bool
+	sumTrailer    bool
+	checksums     checksumCache
+	groupByLetter bool
+	hideSize      bool
+	hideMTime     bool
+
+	childCounts     bool
+	childCountCache childCountCache
+
+	listingPages *listingCache // nil unless --cache-listings
+
+	allowNets  []*net.IPNet
+	denyNets   []*net.IPNet
+	trustProxy bool
+	acl        atomic.Pointer[accessList] // --acl-file rules, nil without one
+
+	formAuth      bool   // --auth-mode=form
+	sessionSecret []byte // signs form login sessions
+	shareSecret   []byte // --share-secret, nil unless links must be signed
+
+	canonicalHost string
+
+	hotlinkHosts  []string // --hotlink-allow, nil without hotlink protection
+	hotlinkSecret []byte   // --hotlink-secret, nil if no file is exempt
+
+	errorDir      string
+	errorTemplate *template.Template
+
+	webhookKey      string
+	webhookPrefixes []string
+
+	blockSourceMaps bool
+	sourceMapNets   []*net.IPNet
+
+	bundle bool
+
+	sendfileHeader string
+	sendfilePrefix string
+	sendfileNets   []*net.IPNet
+
+	copyBuffers *sync.Pool // --copy-buffer buffers, nil for io.Copy's defaults
+
+	openSlots chan struct{} // --max-open-files semaphore, nil without a limit
+}
+
+// requestError carries the HTTP status and message for a rejected request.
+type requestError struct {
+	status int
+	msg    string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
 }
 
 func (fs *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.tracef(r, "%s %q from %s", r.Method, r.URL.Path, r.RemoteAddr)
+	fs.setSecurityHeaders(w)
+	if !fs.clientAllowed(r) {
+		fs.tracef(r, "client %s refused by --allow/--deny", clientIP(r, fs.trustProxy))
+		fs.serveError(w, r, http.StatusForbidden, "Forbidden: Client address not allowed")
+		return
+	}
+
+	if fs.canonicalHost != "" && !strings.EqualFold(r.Host, fs.canonicalHost) {
+		target := requestScheme(r, fs.trustProxy) + "://" + fs.canonicalHost + r.URL.RequestURI()
+		fs.tracef(r, "host %q is not canonical, redirecting", r.Host)
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	if fs.singleFile {
+		fs.serveSingleFile(w, r)
+		return
+	}
+
+	urlPath := r.URL.Path
+	if fs.baseURL != "" {
+		if urlPath != fs.baseURL && !strings.HasPrefix(urlPath, fs.baseURL+"/") {
+			fs.tracef(r, "path is outside --base-url %q", fs.baseURL)
+			fs.serveError(w, r, http.StatusNotFound, "Not Found")
+			return
+		}
+		urlPath = "/" + strings.TrimPrefix(strings.TrimPrefix(urlPath, fs.baseURL), "/")
+	}
+
+	if fs.metrics != nil && urlPath == fs.metricsPath {
+		// Gated like a file at that path: --allow/--deny above, then the
+		// ACL, --share-secret and the other access rules
+		if err := fs.checkAccess(r, filepath.Join(fs.servePath, filepath.FromSlash(urlPath))); err != nil {
+			fs.tracef(r, "access rules refused the metrics endpoint: %v", err)
+			fs.writeRequestError(w, r, err)
+			return
+		}
+		fs.tracef(r, "branch: metrics")
+		fs.metrics.ServeHTTP(w, r)
+		return
+	}
+	if fs.webdav != nil && isWebDAVMethod(r.Method) {
+		fs.tracef(r, "WebDAV %s for %q", r.Method, urlPath)
+		fs.serveWebDAV(w, r, urlPath)
+		return
+	}
+	if len(fs.rewrites) > 0 {
+		rewritten, err := rewritePath(fs.rewrites, urlPath)
+		if err != nil {
+			log.Printf("Error rewriting %s: %v", urlPath, err)
+			fs.serveError(w, r, http.StatusInternalServerError, "Internal Server Error: rewrite loop")
+			return
+		}
+		if rewritten != urlPath {
+			fs.tracef(r, "rewritten to %q", rewritten)
+		}
+		urlPath = rewritten
+	}
+
+	if fs.tus && hasPathPrefix(urlPath, tusEndpoint) {
+		fs.tracef(r, "branch: tus upload")
+		fs.serveTus(w, r, urlPath)
+		return
+	}
+
+	if fs.formAuth && (urlPath == loginPath || urlPath == logoutPath) {
+		fs.tracef(r, "branch: %s", strings.TrimPrefix(urlPath, "/"))
+		if urlPath == loginPath {
+			fs.serveLogin(w, r)
+		} else {
+			fs.serveLogout(w, r)
+		}
+		return
+	}
+
+	if fs.bundle && urlPath == "/.bundle" {
+		fs.serveBundle(w, r)
+		return
+	}
+
 	// Parse the URL path
-	path := strings.TrimPrefix(r.URL.Path, "/")
+	path := strings.TrimPrefix(urlPath, "/")
+
+	// The /raw/ prefix always serves plain file contents
+	raw := false
+	if path == "raw" || strings.HasPrefix(path, "raw/") {
+		raw = true
+		path = strings.TrimPrefix(strings.TrimPrefix(path, "raw"), "/")
+	}
+
+	absPath, err := fs.resolvePath(path)
+	if err != nil {
+		fs.tracef(r, "resolving %q failed containment: %v", path, err)
+		fs.writeRequestError(w, r, err)
+		return
+	}
+	fs.tracef(r, "resolved %q to %s (inside %s)", path, absPath, fs.servePath)
+
+	if err := fs.checkAccess(r, absPath); err != nil {
+		fs.tracef(r, "access rules refused %s: %v", absPath, err)
+		fs.writeRequestError(w, r, err)
+		return
+	}
+
+	if r.Method == http.MethodOptions {
+		fs.tracef(r, "branch: options")
+		fs.serveOptions(w, r, raw)
+		return
+	}
+
+	// Uploads are turned away before anything reads r.Body, so a client
+	// waiting on Expect: 100-continue gets the final status instead of being
+	// told to send the body
+	if (r.Method == http.MethodPut || r.Method == http.MethodPost || r.Method == "MOVE" || r.Method == http.MethodDelete) && (!fs.writable || raw) {
+		fs.tracef(r, "branch: %s refused, read-only", r.Method)
+		w.Header().Set("Allow", fs.allowedMethods(raw))
+		fs.serveError(w, r, http.StatusMethodNotAllowed, "Method Not Allowed: server is read-only")
+		return
+	}
+	if fs.writable && r.Method == http.MethodPut && !raw {
+		fs.tracef(r, "branch: upload (PUT)")
+		fs.handleUpload(w, r, absPath)
+		return
+	}
+	if fs.writable && r.Method == http.MethodPost && !raw {
+		fs.tracef(r, "branch: upload (multipart POST)")
+		fs.handleMultipartUpload(w, r, absPath)
+		return
+	}
+	if fs.writable && r.Method == "MOVE" && !raw {
+		fs.tracef(r, "branch: move")
+		fs.handleMove(w, r, absPath)
+		return
+	}
+	if fs.writable && r.Method == http.MethodDelete && !raw {
+		fs.tracef(r, "branch: delete")
+		fs.handleDelete(w, r, absPath)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		fs.tracef(r, "branch: %s not supported", r.Method)
+		w.Header().Set("Allow", fs.allowedMethods(raw))
+		fs.serveError(w, r, http.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Check if path exists
+	info, err := fs.stat(absPath)
+	if err != nil && fs.cleanURLs && !raw {
+		// Real files and directories win; /about falls back to about.html
+		if page, ok := fs.cleanURLPage(r, absPath); ok {
+			fs.tracef(r, "branch: clean URL %s", page)
+			fs.servePage(w, r, page)
+			return
+		}
+	}
+	if err != nil && fs.spa && !raw {
+		if page, ok := fs.spaPage(r, absPath); ok {
+			fs.tracef(r, "branch: SPA fallback %s", page)
+			fs.servePage(w, r, page)
+			return
+		}
+	}
+	if err != nil && !raw && fs.isFaviconRequest(r, absPath) {
+		fs.tracef(r, "branch: fallback favicon")
+		fs.serveFavicon(w, r)
+		return
+	}
+	if err != nil {
+		fs.tracef(r, "branch: not found (%v)", err)
+		fs.serveError(w, r, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	if !fs.depthAllowed(absPath, info.IsDir()) {
+		fs.tracef(r, "branch: directory beyond --max-depth")
+		fs.serveError(w, r, http.StatusForbidden, "Forbidden: Too deep")
+		return
+	}
+	if !fs.extAllowed(absPath, info.IsDir()) {
+		fs.tracef(r, "branch: extension not in --allow-ext")
+		fs.serveError(w, r, http.StatusNotFound, "Not Found")
+		return
+	}
+	if !info.IsDir() && !fs.hotlinkAllowed(r, absPath) {
+		fs.tracef(r, "branch: hotlink refused")
+		fs.serveError(w, r, http.StatusForbidden, "Forbidden: Hotlinking not allowed")
+		return
+	}
+
+	switch {
+	case raw && info.IsDir():
+		fs.tracef(r, "branch: raw directory, not found")
+		fs.serveError(w, r, http.StatusNotFound, "Not Found")
+	case raw:
+		fs.tracef(r, "branch: raw file")
+		fs.serveRaw(w, r, absPath)
+	case info.IsDir() && fs.noListing:
+		// ZIP downloads would expose the listing just the same
+		fs.tracef(r, "branch: directory, listing disabled")
+		fs.serveError(w, r, http.StatusForbidden, "Forbidden: Directory listing disabled")
+	case info.IsDir() && wantsZip(r) && fs.archive == nil:
+		fs.tracef(r, "branch: ZIP download")
+		fs.serveZip(w, r, absPath, r.URL.Query().Get("recursive") == "true")
+	case info.IsDir():
+		fs.tracef(r, "branch: directory listing")
+		fs.timed(w, r, func(w http.ResponseWriter, r *http.Request) {
+			fs.serveDirectory(w, r, absPath, path)
+		})
+	case fs.wantsPreview(r, info):
+		fs.tracef(r, "branch: preview")
+		fs.servePreview(w, r, absPath)
+	case fs.archive != nil:
+		// Thumbnails, checksums and the like work on files on disk
+		fs.tracef(r, "branch: file in archive")
+		fs.serveFile(w, r, absPath)
+	case fs.thumbnails && r.URL.Query().Has("thumbnail") && isThumbnailable(absPath):
+		fs.tracef(r, "branch: thumbnail")
+		fs.timed(w, r, func(w http.ResponseWriter, r *http.Request) {
+			fs.serveThumbnail(w, r, absPath)
+		})
+	case r.URL.Query().Has("checksum"):
+		fs.tracef(r, "branch: checksum")
+		fs.timed(w, r, func(w http.ResponseWriter, r *http.Request) {
+			fs.serveChecksum(w, r, absPath)
+		})
+	case fs.allowFollow && r.URL.Query().Get("follow") == "true" && info.Mode().IsRegular():
+		fs.tracef(r, "branch: follow")
+		fs.serveFollow(w, r, absPath)
+	case fs.mediaPlayer && wantsMediaPlayer(r, info):
+		fs.tracef(r, "branch: media player")
+		fs.serveMediaPlayer(w, r, absPath, info)
+	case fs.renderMarkdown && wantsRenderedMarkdown(r, info):
+		fs.tracef(r, "branch: rendered Markdown")
+		fs.timed(w, r, func(w http.ResponseWriter, r *http.Request) {
+			fs.serveMarkdown(w, r, absPath, info)
+		})
+	default:
+		fs.tracef(r, "branch: file")
+		fs.serveFile(w, r, absPath)
+	}
+}
+
+// tracef logs a --verbose line about how r is being handled, tagged with
+// its request ID. Only paths are logged, never query strings or headers,
+// which may carry secrets such as ?key= or Authorization.
+func (fs *FileServer) tracef(r *http.Request, format string, args ...any) {
+	if !fs.verbose {
+		return
+	}
+	log.Printf("[%s] "+format, append([]any{requestID(r)}, args...)...)
+}
+
+// normalizeBaseURL turns a --base-url value into "" or "/prefix", so
+// "files", "/files" and "/files/" all mean the same mount point.
+func normalizeBaseURL(value string) string {
+	value = strings.Trim(value, "/")
+	if value == "" {
+		return ""
+	}
+	return "/" + value
+}
 
+// serveSingleFile answers every request with the served file when --folder
+// names a regular file. There is no tree to traverse, so request paths are
+// ignored (any name works for downloads) and uploads are not accepted.
+func (fs *FileServer) serveSingleFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD")
+		fs.serveError(w, r, http.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+	// The served file is the root, so it is "/" to the access rules
+	if err := fs.checkAccess(r, fs.servePath); err != nil {
+		fs.writeRequestError(w, r, err)
+		return
+	}
+	if !fs.hotlinkAllowed(r, fs.servePath) {
+		fs.serveError(w, r, http.StatusForbidden, "Forbidden: Hotlinking not allowed")
+		return
+	}
+	if r.URL.Query().Has("checksum") {
+		fs.serveChecksum(w, r, fs.servePath)
+		return
+	}
+	fs.serveFile(w, r, fs.servePath)
+}
+
+// resolvePath maps a slash-separated path relative to the serve root onto
+// an absolute filesystem path, rejecting anything that escapes the root.
+func (fs *FileServer) resolvePath(path string) (string, error) {
 	// Security check: prevent directory traversal
 	if strings.Contains(path, "..") || strings.HasPrefix(path, "/") {
-		http.Error(w, "Forbidden: Directory traversal not allowed", http.StatusForbidden)
+		return "", &requestError{http.StatusForbidden, "Forbidden: Directory traversal not allowed"}
+	}
+
+	// Build full file path
+	fullPath := filepath.Join(fs.servePath, path)
+
+	// Resolve absolute path and check it's within serve directory
+	absPath, err := filepath.Abs(fullPath)
+	if err != nil {
+		return "", &requestError{http.StatusNotFound, "Not Found"}
+	}
+
+	// Security check: ensure path is within serve directory
+	serveAbsPath, _ := filepath.Abs(fs.servePath)
+	if !strings.HasPrefix(absPath, serveAbsPath) {
+		return "", &requestError{http.StatusForbidden, "Forbidden: Path outside serve directory"}
+	}
+
+	if len(fs.overlays) > 0 {
+		return fs.locate(fs.rootRelative(absPath)), nil
+	}
+	return absPath, nil
+}
+
+// writeAllowed applies --write-prefix to the target of a write.
+func (fs *FileServer) writeAllowed(absPath string) bool {
+	return fs.writePrefix == "" || hasPathPrefix(fs.rootRelative(absPath), fs.writePrefix)
+}
+
+// writeForbidden answers a write that --write-prefix refuses.
+func (fs *FileServer) writeForbidden(w http.ResponseWriter, r *http.Request) {
+	fs.serveError(w, r, http.StatusForbidden, "Forbidden: writes are only allowed below "+fs.writePrefix)
+}
+
+// checkAccess applies the per-path access rules to a resolved path. It runs
+// for every path a request touches, not just the request URL.
+func (fs *FileServer) checkAccess(r *http.Request, absPath string) error {
+	if fs.isHidden(fs.rootRelative(absPath)) {
+		return &requestError{http.StatusNotFound, "Not Found"}
+	}
+	if !fs.webhookKeyValid(r, fs.rootRelative(absPath)) {
+		return &requestError{http.StatusForbidden, "Forbidden: Missing or invalid key"}
+	}
+	if !fs.shareLinkValid(r, fs.rootRelative(absPath)) {
+		return &requestError{http.StatusForbidden, "Forbidden: Missing, invalid or expired link"}
+	}
+	if !fs.sourceMapAllowed(r, absPath) {
+		return &requestError{http.StatusNotFound, "Not Found"}
+	}
+	if err := fs.aclAllowed(r, absPath); err != nil {
+		return err
+	}
+	// Whether the path is a directory isn't known yet; ServeHTTP checks
+	// again once it is
+	if !fs.depthAllowed(absPath, false) {
+		return &requestError{http.StatusForbidden, "Forbidden: Too deep"}
+	}
+	return nil
+}
+
+// depthAllowed applies --max-depth: a directory may be at most that many
+// segments below the serve root, and a file may be in any such directory.
+func (fs *FileServer) depthAllowed(absPath string, isDir bool) bool {
+	if fs.maxDepth < 0 {
+		return true
+	}
+	depth := 0
+	if rel := fs.rootRelative(absPath); rel != "/" {
+		depth = strings.Count(rel, "/")
+	}
+	if !isDir {
+		depth--
+	}
+	return depth <= fs.maxDepth
+}
+
+// rootRelative returns the cleaned, slash-separated path of absPath below
+// the serve root, starting with "/" ("/" for the root itself). Path based
+// rules match against this form so that equivalent spellings of a request
+// path can't slip past them.
+func (fs *FileServer) rootRelative(absPath string) string {
+	root := fs.servePath
+	for _, overlay := range fs.overlays {
+		if isWithin(absPath, overlay) {
+			root = overlay
+		}
+	}
+	rel, err := filepath.Rel(root, absPath)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+func (fs *FileServer) serveFile(w http.ResponseWriter, r *http.Request, filePath string) {
+	if fs.wantsSourceView(r, filePath) {
+		// Byte for byte as stored, only labeled as text
+		fs.sendFile(w, r, filePath, "text/plain; charset=utf-8", "inline")
+		return
+	}
+	fs.sendFile(w, r, filePath, getMimeType(filepath.Base(filePath)), "attachment")
+}
+
+// servePage serves an HTML page inline, for pages the server picks itself
+// (--clean-urls, --spa) rather than files a client asked to download.
+func (fs *FileServer) servePage(w http.ResponseWriter, r *http.Request, filePath string) {
+	fs.sendFile(w, r, filePath, getMimeType(filepath.Base(filePath)), "inline")
+}
+
+// cleanURLPage returns the name.html file that stands in for a missing
+// extensionless absPath with --clean-urls, if there is an accessible one.
+func (fs *FileServer) cleanURLPage(r *http.Request, absPath string) (string, bool) {
+	if filepath.Ext(absPath) != "" {
+		return "", false
+	}
+	page := absPath + ".html"
+	if info, err := os.Stat(page); err != nil || !info.Mode().IsRegular() || !fs.extAllowed(page, false) {
+		return "", false
+	}
+	if fs.checkAccess(r, page) != nil {
+		return "", false
+	}
+	return page, true
+}
+
+// spaAPIPrefix is never answered with the --spa fallback, so API clients
+// still see their 404s.
+const spaAPIPrefix = "/api"
+
+// spaPage returns the root index.html that --spa serves for unknown
+// routes. Paths with an extension ask for a static asset and paths under
+// spaAPIPrefix for an API, so those keep their 404.
+func (fs *FileServer) spaPage(r *http.Request, absPath string) (string, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "", false
+	}
+	if filepath.Ext(absPath) != "" || hasPathPrefix(fs.rootRelative(absPath), spaAPIPrefix) {
+		return "", false
+	}
+	page := filepath.Join(fs.servePath, "index.html")
+	if info, err := os.Stat(page); err != nil || !info.Mode().IsRegular() || !fs.extAllowed(page, false) {
+		return "", false
+	}
+	if fs.checkAccess(r, page) != nil {
+		return "", false
+	}
+	return page, true
+}
+
+// serveRaw serves the file contents inline without any download or
+// rendering behavior. Text types are always sent as text/plain.
+func (fs *FileServer) serveRaw(w http.ResponseWriter, r *http.Request, filePath string) {
+	mimeType := getMimeType(filepath.Base(filePath))
+	if isTextMime(mimeType) {
+		mimeType = "text/plain; charset=utf-8"
+	}
+	fs.sendFile(w, r, filePath, mimeType, "inline")
+}
+
+// sendFile writes the file at filePath with the given Content-Type and
+// Content-Disposition type. ?filename= renames the download.
+func (fs *FileServer) sendFile(w http.ResponseWriter, r *http.Request, filePath, mimeType, disposition string) {
+	filename, err := downloadName(r, filePath)
+	if err != nil {
+		fs.writeRequestError(w, r, err)
 		return
 	}
-
-	// Build full file path
-	fullPath := filepath.Join(fs.servePath, path)
-
-	// Resolve absolute path and check it's within serve directory
-	absPath, err := filepath.Abs(fullPath)
+	content, info, err := fs.openContent(filePath)
 	if err != nil {
-		http.Error(w, "Not Found", http.StatusNotFound)
+		fs.serveReadError(w, r, err)
 		return
 	}
+	if closer, ok := content.(io.Closer); ok {
+		defer closer.Close()
+	}
 
-	// Security check: ensure path is within serve directory
-	serveAbsPath, _ := filepath.Abs(fs.servePath)
-	if !strings.HasPrefix(absPath, serveAbsPath) {
-		http.Error(w, "Forbidden: Path outside serve directory", http.StatusForbidden)
+	// Set headers
+	if fs.hardenSVG && mimeType == "image/svg+xml" {
+		// SVG can carry scripts; keep it out of the page origin.
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, filename))
+	w.Header().Set("ETag", fileETag(info))
+	if cacheControl := fs.cacheControl(filename); cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	if fs.offloadFile(w, r, filePath) {
 		return
 	}
 
-	// Check if path exists
-	info, err := os.Stat(absPath)
-	if os.IsNotExist(err) {
-		http.Error(w, "Not Found", http.StatusNotFound)
+	w = fs.throttle(w, r)
+
+	if (len(fs.encodings) > 0 && isCompressible(mimeType)) || len(fs.sidecars) > 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if sidecar, sidecarInfo, encoding := fs.openSidecar(r, filePath); sidecar != nil {
+		// The sidecar is its own representation: its ETag and length, but
+		// still the original file's name and Content-Type
+		defer sidecar.Close()
+		w.Header().Set("ETag", strings.TrimSuffix(fileETag(sidecarInfo), `"`)+"-"+encoding+`"`)
+		w.Header().Set("Content-Encoding", encoding)
+		fs.serveContent(w, r, filename, sidecarInfo.ModTime(), sidecar)
 		return
 	}
+	if encoding := fs.negotiateEncoding(r, mimeType, info.Size()); encoding != "" {
+		// Ranges refer to the uncompressed bytes, so a compressed response
+		// is always the full representation.
+		r = r.Clone(r.Context())
+		r.Header.Del("Range")
+		w.Header().Set("ETag", strings.TrimSuffix(fileETag(info), `"`)+"-"+encoding+`"`)
+		w.Header().Set("Content-Encoding", encoding)
 
-	if info.IsDir() {
-		fs.serveDirectory(w, r, absPath, path)
-	} else {
-		fs.serveFile(w, r, absPath)
+		cw := newCompressWriter(w, encoding)
+		defer cw.Close()
+		w = cw
 	}
-}
 
-func (fs *FileServer) serveFile(w http.ResponseWriter, r *http.Request, filePath string) {
-	// Open file
-	file, err := os.Open(filePath)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error reading file: %v", err), http.StatusInternalServerError)
+	if fs.wantsChecksumTrailer(w, r) {
+		fs.serveWithChecksumTrailer(w, r, filename, info, content)
 		return
 	}
-	defer file.Close()
 
-	// Get file info
+	// ServeContent derives Content-Length from the content and also
+	// takes care of Range and conditional requests.
+	fs.serveContent(w, r, filename, info.ModTime(), content)
+}
+
+// openContent returns the contents of filePath for sendFile: from the
+// --cache-size cache when it holds a current copy, otherwise from disk.
+// Files small enough for the cache are read into it on the way. Either way
+// the result is a ReadSeeker with the file's current FileInfo, so Range,
+// ETag and conditional requests work the same on a cache hit as on a miss.
+// The caller closes the result if it is an io.Closer.
+func (fs *FileServer) openContent(filePath string) (io.ReadSeeker, os.FileInfo, error) {
+	if fs.archive != nil {
+		return fs.archive.open(fs.rootRelative(filePath))
+	}
+	if data, info, ok := fs.fileCache.get(filePath); ok {
+		return bytes.NewReader(data), info, nil
+	}
+
+	file, err := fs.openFile(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
 	info, err := file.Stat()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error reading file: %v", err), http.StatusInternalServerError)
-		return
+		file.Close()
+		return nil, nil, err
+	}
+	if !fs.fileCache.fits(info.Size()) {
+		return file, info, nil
 	}
 
-	// Set headers
-	filename := filepath.Base(filePath)
-	w.Header().Set("Content-Type", getMimeType(filename))
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", info.Size()))
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-
-	// Copy file to response
-	_, err = io.Copy(w, file)
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, info.Size()))
 	if err != nil {
-		log.Printf("Error writing file: %v", err)
+		return nil, nil, err
 	}
+	if int64(len(data)) == info.Size() {
+		fs.fileCache.put(filePath, info, data)
+	}
+	return bytes.NewReader(data), info, nil
 }
 
 func (fs *FileServer) serveDirectory(w http.ResponseWriter, r *http.Request, dirPath, urlPath string) {
 	// Read directory contents
-	entries, err := os.ReadDir(dirPath)
+	entries, err := fs.readDir(dirPath)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error reading directory: %v", err), http.StatusInternalServerError)
+		fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error reading directory: %v", err))
 		return
 	}
 
 	// Convert to FileInfo slice
 	var files []FileInfo
+	var readme struct{ path, name, url string } // the preferred README for --show-readme
+	cacheable := fs.cachesListing(r)
+	signature := newListingSignature()
 	for _, entry := range entries {
 		info, err := entry.Info()
 		if err != nil {
 			continue
 		}
+		if cacheable {
+			signature.add(entry.Name(), entry.IsDir(), info.Size(), info.ModTime().UnixNano())
+		}
+
+		entryPath := entry.path
+		if fs.isHidden(fs.rootRelative(entryPath)) || !fs.extAllowed(entryPath, entry.IsDir()) {
+			continue
+		}
+		if fs.hideEmptyDirs && entry.IsDir() && fs.isEmptyDir(entryPath) {
+			continue
+		}
 
 		fileInfo := FileInfo{
 			Name:    entry.Name(),
@@ -166,115 +1785,661 @@ func (fs *FileServer) serveDirectory(w http.ResponseWriter, r *http.Request, dir
 		}
 
 		// Build URL
-		if urlPath != "" {
-			fileInfo.URL = "/" + urlPath + "/" + entry.Name()
+		if dir := strings.Trim(urlPath, "/"); dir != "" {
+			fileInfo.URL = fs.baseURL + "/" + dir + "/" + entry.Name()
 		} else {
-			fileInfo.URL = "/" + entry.Name()
+			fileInfo.URL = fs.baseURL + "/" + entry.Name()
 		}
 
 		// Add trailing slash for directories
 		if entry.IsDir() {
 			fileInfo.URL += "/"
 		}
+		// Directories past --max-depth are listed but not linked
+		if entry.IsDir() && !fs.depthAllowed(entryPath, true) {
+			fileInfo.URL = ""
+		}
+
+		if fs.thumbnails && !entry.IsDir() && isThumbnailable(entry.Name()) {
+			fileInfo.Thumbnail = fileInfo.URL + "?thumbnail=1"
+		}
+		if fs.previewSize > 0 && !entry.IsDir() && isPreviewable(entry.Name()) {
+			fileInfo.Preview = fileInfo.URL + "?preview=true"
+		}
+		if fs.showReadme && !entry.IsDir() {
+			if rank := readmeRank(entry.Name()); rank >= 0 && (readme.path == "" || rank < readmeRank(readme.name)) {
+				readme.path, readme.name, readme.url = entryPath, entry.Name(), fileInfo.URL
+			}
+		}
 
 		files = append(files, fileInfo)
 	}
 
-	// Sort files: directories first, then files, both alphabetically
-	sort.Slice(files, func(i, j int) bool {
-		if files[i].IsDir != files[j].IsDir {
-			return files[i].IsDir
+	// An unchanged directory gets the page generated last time, skipping
+	// the sorting and rendering below
+	cacheKey := listingKey{dir: dirPath, signature: signature.h.Sum64(), query: r.URL.RawQuery}
+	if cacheable {
+		if cached, ok := fs.listingPages.get(cacheKey); ok {
+			fs.tracef(r, "listing served from --cache-listings")
+			w.Header().Add("Vary", "Accept")
+			w.Header().Set("ETag", cached.etag)
+			setAge(w, cached.created)
+			if etagMatches(r.Header.Get("If-None-Match"), cached.etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			fs.writeListingPage(w, r, cached.page)
+			return
 		}
-		return files[i].Name < files[j].Name
-	})
+	}
+
+	// Filter by ?search= (case-insensitive substring of the name)
+	search := strings.TrimSpace(r.URL.Query().Get("search"))
+	if search != "" {
+		files = filterByName(files, search)
+	}
+
+	// Filter by ?type= group; directories stay for navigation
+	typeFilter := r.URL.Query().Get("type")
+	if !isFileTypeGroup(typeFilter) {
+		typeFilter = ""
+	}
+	if typeFilter != "" {
+		files = filterByType(files, typeFilter)
+	}
+
+	// ?sort=name|size|modified and ?order=desc; letter headings only make
+	// sense in plain name order
+	sortKey, desc := parseSort(r.URL.Query())
+	if (sortKey == "size" && fs.hideSize) || (sortKey == "modified" && fs.hideMTime) {
+		// The order would give away what the listing leaves out
+		sortKey = "name"
+	}
+	grouped := fs.groupByLetter && sortKey == "name" && !desc
+	if grouped {
+		sortByLetter(files, fs.sortNatural)
+	} else {
+		sortFiles(files, sortKey, desc, fs.sortNatural)
+	}
+
+	// Clients polling an unchanged directory get a 304 before any of the
+	// rendering work below
+	text := wantsTextListing(r)
+	jsonList := !text && wantsJSONListing(r)
+	etag := listingETag(files)
+	if text {
+		etag = strings.TrimSuffix(etag, `"`) + `-text"`
+	} else if jsonList {
+		etag = strings.TrimSuffix(etag, `"`) + `-json"`
+	}
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	// --max-listing-entries applies after sorting, so the same entries are
+	// shown every time; the ETag and the summary still cover them all
+	total := len(files)
+	shown := files
+	if fs.maxListing > 0 && total > fs.maxListing {
+		shown = files[:fs.maxListing]
+	}
+	if text {
+		if len(shown) < total {
+			w.Header().Set("X-Listing-Truncated", fmt.Sprintf("%d of %d", len(shown), total))
+		}
+		writeTextListing(w, r, shown)
+		return
+	}
 
 	// Create directory listing
 	listing := DirectoryListing{
-		Path:  urlPath,
-		Files: files,
+		Path:        urlPath,
+		ParentURL:   fs.baseURL + "/",
+		Favicon:     fs.baseURL + "/favicon.ico",
+		Search:      search,
+		Type:        typeFilter,
+		Sort:        sortKey,
+		Desc:        desc,
+		TimeFormat:  fs.timeFormat,
+		ClientSort:  fs.clientSort,
+		Checksums:   fs.showChecksums,
+		ChildCounts: fs.childCounts,
+		Columns:     2,
+
+		HideSize:  fs.hideSize,
+		HideMTime: fs.hideMTime,
+
+		RelativeTime: fs.relativeTime,
+		Now:          time.Now(),
+
+		Archive: fs.archive != nil,
+	}
+	if parent := path.Dir(strings.Trim(urlPath, "/")); parent != "." {
+		listing.ParentURL += parent + "/"
+	}
+	switch relative := r.URL.Query().Get("relative"); relative {
+	case "true", "false":
+		listing.RelativeTime = relative == "true"
+		listing.Relative = relative
+	}
+	listing.TypeChips = typeChips(&listing)
+	if readme.path != "" {
+		listing.Readme = fs.renderReadme(readme.path, readme.name, readme.url)
+	}
+	if !listing.HideSize {
+		listing.Columns++
+	}
+	if !listing.HideMTime {
+		listing.Columns++
+	}
+	if listing.Checksums {
+		listing.Columns++
+	}
+	if listing.ChildCounts {
+		listing.Columns++
+	}
+	for _, f := range files {
+		if f.IsDir {
+			listing.DirCount++
+		} else {
+			listing.FileCount++
+			listing.TotalSize += f.Size
+		}
+	}
+
+	if len(shown) < total {
+		listing.Shown, listing.Total = len(shown), total
+	}
+
+	// Paginate after sorting, so page boundaries are stable; the summary
+	// above still counts the whole directory
+	listing.Files = paginate(&listing, shown, r.URL.Query())
+	if grouped {
+		markLetters(listing.Files)
+	}
+	if fs.childCounts {
+		// Only for the current page, to bound the cost
+		for i, f := range listing.Files {
+			if f.IsDir {
+				childPath := filepath.Join(dirPath, f.Name)
+				switch {
+				case fs.archive != nil:
+					if node, err := fs.archive.stat(fs.rootRelative(childPath)); err == nil {
+						listing.Files[i].Children = strconv.Itoa(len(node.children))
+					}
+					continue
+				case len(fs.overlays) > 0:
+					childPath = fs.locate(fs.rootRelative(childPath))
+				}
+				listing.Files[i].Children = fs.childCountCache.count(childPath, f.ModTime)
+			}
+		}
+	}
+
+	if jsonList {
+		writeJSONListing(w, r, &listing)
+		return
 	}
 
 	// Generate HTML
 	html, err := fs.generateDirectoryHTML(listing)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error generating HTML: %v", err), http.StatusInternalServerError)
+		fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error generating HTML: %v", err))
 		return
 	}
 
-	// Send response
+	page := []byte(html)
+	if cacheable {
+		fs.listingPages.put(cacheKey, etag, page)
+	}
+	fs.writeListingPage(w, r, page)
+}
+
+// writeListingPage sends a generated HTML listing.
+func (fs *FileServer) writeListingPage(w http.ResponseWriter, r *http.Request, page []byte) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(html))
+	w.Header().Set("Content-Security-Policy", listingCSP)
+	w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+	if r.Method != http.MethodHead {
+		w.Write(page)
+	}
 }
 
-func (fs *FileServer) generateDirectoryHTML(listing DirectoryListing) (string, error) {
-	tmpl := `<!DOCTYPE html>
-<html>
-<head>
-    <title>Directory listing for {{.Path}}</title>
-    <style>
-        body { font-family: Arial, sans-serif; margin: 20px; }
-        h1 { color: #333; }
+// wantsTextListing reports whether a directory request asks for the plain
+// list of names: with ?format=text, or Accept: text/plain from a client
+// that doesn't also take HTML.
+func wantsTextListing(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "text" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") && !strings.Contains(accept, "text/html")
+}
+
+// writeTextListing writes one entry name per line, directories with a
+// trailing slash, for scripts reading a listing line by line. It isn't
+// paginated.
+func writeTextListing(w http.ResponseWriter, r *http.Request, files []FileInfo) {
+	var b strings.Builder
+	for _, f := range files {
+		b.WriteString(f.Name)
+		if f.IsDir {
+			b.WriteByte('/')
+		}
+		b.WriteByte('\n')
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+	if r.Method != http.MethodHead {
+		io.WriteString(w, b.String())
+	}
+}
+
+// isEmptyDir reports whether the directory at path has no visible entries,
+// i.e. none that --hide or --allow-ext leaves out. Entries are read in small batches and
+// the scan stops at the first visible one, so the check stays cheap for
+// huge directories. Unreadable directories count as empty since they can't
+// be browsed.
+func (fs *FileServer) isEmptyDir(path string) bool {
+	if fs.archive != nil {
+		entries, _ := fs.archive.readDir(fs.rootRelative(path))
+		for _, entry := range entries {
+			entryPath := filepath.Join(path, entry.name)
+			if !fs.isHidden(fs.rootRelative(entryPath)) && fs.extAllowed(entryPath, entry.IsDir()) {
+				return false
+			}
+		}
+		return true
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		return true
+	}
+	defer dir.Close()
+
+	for {
+		entries, err := dir.ReadDir(16)
+		for _, entry := range entries {
+			entryPath := filepath.Join(path, entry.Name())
+			if !fs.isHidden(fs.rootRelative(entryPath)) && fs.extAllowed(entryPath, entry.IsDir()) {
+				return false
+			}
+		}
+		if err != nil {
+			return true
+		}
+	}
+}
+
+// parseSort reads the ?sort= key (name, size or modified; name if missing
+// or unknown) and whether ?order=desc reverses it.
+func parseSort(query url.Values) (string, bool) {
+	key := query.Get("sort")
+	switch key {
+	case "name", "size", "modified":
+	default:
+		key = "name"
+	}
+	return key, query.Get("order") == "desc"
+}
+
+// sortFiles orders files by key, directories always first. Names compare
+// case-insensitively, in natural order ("file2" before "file10") when
+// natural is set. Sizes and times are compared in full (nanoseconds for
+// ModTime, not the minutes shown) and ties are broken by name, so the order
+// is deterministic.
+func sortFiles(files []FileInfo, key string, desc, natural bool) {
+	newFileOrder(files, key, desc, natural, true).sort()
+}
+
+// sortByLetter sorts files by name with directories mixed in, the order
+// --group-by-letter headings need.
+func sortByLetter(files []FileInfo, natural bool) {
+	newFileOrder(files, "name", false, natural, false).sort()
+}
+
+// fileOrder is a sort.Interface over listing entries. It sorts small
+// (lower-cased name, index) pairs instead of the entries themselves: the
+// names are lowered once up front rather than in every comparison, and
+// swaps don't move whole FileInfo values, which matters for directories
+// with hundreds of thousands of entries.
+type fileOrder struct {
+	files     []FileInfo
+	keys      []fileKey
+	key       string
+	desc      bool
+	natural   bool
+	dirsFirst bool
+}
+
+type fileKey struct {
+	name  string // lower-cased files[index].Name
+	isDir bool
+	index int
+}
+
+func newFileOrder(files []FileInfo, key string, desc, natural, dirsFirst bool) *fileOrder {
+	keys := make([]fileKey, len(files))
+	for i, f := range files {
+		keys[i] = fileKey{strings.ToLower(f.Name), f.IsDir, i}
+	}
+	return &fileOrder{files: files, keys: keys, key: key, desc: desc, natural: natural, dirsFirst: dirsFirst}
+}
+
+// sort sorts the keys, then moves the entries into that order in one pass.
+func (o *fileOrder) sort() {
+	sort.Sort(o)
+	sorted := make([]FileInfo, len(o.files))
+	for i, k := range o.keys {
+		sorted[i] = o.files[k.index]
+	}
+	copy(o.files, sorted)
+}
+
+func (o *fileOrder) Len() int { return len(o.keys) }
+
+func (o *fileOrder) Swap(i, j int) { o.keys[i], o.keys[j] = o.keys[j], o.keys[i] }
+
+func (o *fileOrder) Less(i, j int) bool {
+	if o.dirsFirst && o.keys[i].isDir != o.keys[j].isDir {
+		return o.keys[i].isDir
+	}
+	a, b := &o.files[o.keys[i].index], &o.files[o.keys[j].index]
+	switch o.key {
+	case "size":
+		if a.Size != b.Size {
+			return (a.Size < b.Size) != o.desc
+		}
+	case "modified":
+		if !a.ModTime.Equal(b.ModTime) {
+			return a.ModTime.Before(b.ModTime) != o.desc
+		}
+	case "name":
+		return (o.compareNames(i, j) < 0) != o.desc
+	}
+	return o.compareNames(i, j) < 0
+}
+
+// compareNames compares keys i and j by name, falling back to the exact
+// spelling when the names only differ in case.
+func (o *fileOrder) compareNames(i, j int) int {
+	var c int
+	if o.natural {
+		c = naturalCompare(o.keys[i].name, o.keys[j].name)
+	} else {
+		c = strings.Compare(o.keys[i].name, o.keys[j].name)
+	}
+	if c == 0 {
+		c = strings.Compare(o.files[o.keys[i].index].Name, o.files[o.keys[j].index].Name)
+	}
+	return c
+}
+
+// naturalCompare compares a and b like strings.Compare, except that runs of
+// digits compare by their numeric value, so "file2" sorts before "file10".
+// Numbers of any length work; they are compared as digit strings.
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			numA, restA := splitDigits(a)
+			numB, restB := splitDigits(b)
+			trimmedA, trimmedB := strings.TrimLeft(numA, "0"), strings.TrimLeft(numB, "0")
+			if len(trimmedA) != len(trimmedB) {
+				if len(trimmedA) < len(trimmedB) {
+					return -1
+				}
+				return 1
+			}
+			if c := strings.Compare(trimmedA, trimmedB); c != 0 {
+				return c
+			}
+			// Equal values: "01" and "1" are told apart by the rest, or
+			// else by the plain comparison at the end
+			a, b = restA, restB
+			continue
+		}
+		if a[0] != b[0] {
+			if a[0] < b[0] {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) - len(b)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// splitDigits splits s after its leading run of ASCII digits.
+func splitDigits(s string) (digits, rest string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// markLetters gives the first entry of each initial its Letter heading.
+// Names not starting with a letter are grouped under "#".
+func markLetters(files []FileInfo) {
+	last := ""
+	for i := range files {
+		letter := "#"
+		if r, _ := utf8.DecodeRuneInString(files[i].Name); unicode.IsLetter(r) {
+			letter = string(unicode.ToUpper(r))
+		}
+		if letter != last {
+			files[i].Letter = letter
+			last = letter
+		}
+	}
+}
+
+// paginate returns the page of files selected by the ?page= and
+// ?per_page= parameters in query, recording the page and the links to its
+// neighbours in listing. Missing or invalid values fall back to the first
+// page of defaultPerPage entries.
+func paginate(listing *DirectoryListing, files []FileInfo, query url.Values) []FileInfo {
+	perPage, err := strconv.Atoi(query.Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = defaultPerPage
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	pages := (len(files) + perPage - 1) / perPage
+	if pages == 0 {
+		pages = 1
+	}
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	if page > pages {
+		page = pages
+	}
+
+	listing.Page, listing.Pages, listing.PerPage = page, pages, perPage
+	pageURL := func(n int) string {
+		q := listingQuery(listing)
+		q.Set("page", strconv.Itoa(n))
+		if perPage != defaultPerPage {
+			q.Set("per_page", strconv.Itoa(perPage))
+		}
+		return "?" + q.Encode()
+	}
+	if page > 1 {
+		listing.PrevURL = pageURL(page - 1)
+	}
+	if page < pages {
+		listing.NextURL = pageURL(page + 1)
+	}
+
+	start := (page - 1) * perPage
+	end := start + perPage
+	if end > len(files) {
+		end = len(files)
+	}
+	return files[start:end]
+}
+
+// filterByName keeps the entries whose name contains term, ignoring case.
+func filterByName(files []FileInfo, term string) []FileInfo {
+	term = strings.ToLower(term)
+	var matched []FileInfo
+	for _, f := range files {
+		if strings.Contains(strings.ToLower(f.Name), term) {
+			matched = append(matched, f)
+		}
+	}
+	return matched
+}
+
+// listingCSS is the stylesheet of directory listings. listingCSP allows it
+// by hash, so it must not depend on the listing.
+const listingCSS = `
+` + pageCSS + `
         table { border-collapse: collapse; width: 100%; }
         th, td { border: 1px solid #ddd; padding: 8px; text-align: left; }
         th { background-color: #f2f2f2; }
-        a { text-decoration: none; color: #0066cc; }
-        a:hover { text-decoration: underline; }
         .file-icon { color: #666; }
         .dir-icon { color: #ff6600; }
-    </style>
+        .search { margin-bottom: 15px; }
+        .search input[type=text] { padding: 6px; width: 250px; }
+        .zip { margin-left: 15px; }
+        .types { margin: 0 0 15px; }
+        .chip { display: inline-block; padding: 3px 10px; margin-right: 6px; border: 1px solid #ddd; border-radius: 12px; text-decoration: none; }
+        .chip.active { background-color: #ff6600; border-color: #ff6600; color: #fff; }
+        .preview { font-size: 0.85em; color: #666; margin-left: 6px; }
+        .thumb { max-width: 64px; max-height: 64px; vertical-align: middle; margin-right: 6px; }
+        tr.letter th { background-color: transparent; color: #ff6600; border-bottom: 2px solid #ff6600; }
+        th.sortable { cursor: pointer; user-select: none; }
+        th.sortable:hover { background-color: #e6e6e6; }
+        .summary { color: #666; margin: 10px 0; }
+        .pages { margin: 10px 0; }
+        .truncated { color: #c60; }
+        .pages a { margin: 0 10px; }
+` + markdownCSS + `
+        .readme { margin-top: 20px; border: 1px solid #ddd; padding: 0 16px 16px; }
+        .readme h2.name { font-size: 1em; color: #666; border-bottom: 1px solid #ddd; padding: 8px 0; }
+    `
+
+// clientSortScript sorts --client-sort listing rows in place when a column
+// header is clicked; directories stay first, and letter headings go since
+// they only make sense in name order. listingCSP allows it by hash, and
+// html/template strips JS comments, so it has none of its own.
+const clientSortScript = `
+    document.querySelectorAll("th.sortable").forEach(function (th) {
+        var asc = true;
+        th.addEventListener("click", function () {
+            var key = th.dataset.key;
+            var tbody = document.querySelector("tbody");
+            var rows = Array.prototype.slice.call(tbody.querySelectorAll("tr.entry"));
+            tbody.querySelectorAll("tr.letter").forEach(function (row) { row.remove(); });
+            rows.sort(function (a, b) {
+                if (a.dataset.dir !== b.dataset.dir) {
+                    return b.dataset.dir - a.dataset.dir;
+                }
+                var x = a.dataset[key], y = b.dataset[key];
+                var cmp = key === "name" ? x.localeCompare(y) : x - y;
+                if (cmp === 0) {
+                    cmp = a.dataset.name.localeCompare(b.dataset.name);
+                }
+                return asc ? cmp : -cmp;
+            });
+            rows.forEach(function (row) { tbody.appendChild(row); });
+            asc = !asc;
+        });
+    });
+    `
+
+func (fs *FileServer) generateDirectoryHTML(listing DirectoryListing) (string, error) {
+	tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>Directory listing for {{.Path}}</title>
+    <link rel="icon" href="{{.Favicon}}">
+    <style>` + listingCSS + `</style>
 </head>
 <body>
     <h1>Directory listing for {{.Path}}</h1>
+    <form class="search" method="get">
+        <input type="text" name="search" value="{{.Search}}" placeholder="Search this directory">
+        {{if .Type}}<input type="hidden" name="type" value="{{.Type}}">{{end}}
+        <input type="submit" value="Search">
+        {{if .Search}}<a href="?">Clear</a>{{end}}
+        {{if not .Archive}}<a class="zip" href="?download=zip&amp;recursive=true">⬇ Download as ZIP</a>{{end}}
+    </form>
+    <p class="types">{{range .TypeChips}}<a class="chip{{if .Active}} active{{end}}" href="{{.URL}}">{{.Label}}</a>{{end}}</p>
     <table>
         <thead>
             <tr>
-                <th>Name</th>
+                <th{{if .ClientSort}} class="sortable" data-key="name"{{end}}>Name</th>
                 <th>Type</th>
-                <th>Size</th>
-                <th>Modified</th>
+                {{if not .HideSize}}<th{{if .ClientSort}} class="sortable" data-key="size"{{end}}>Size</th>{{end}}
+                {{if not .HideMTime}}<th{{if .ClientSort}} class="sortable" data-key="mtime"{{end}}>Modified</th>{{end}}
+                {{if .ChildCounts}}<th>Items</th>{{end}}
+                {{if .Checksums}}<th>Checksum</th>{{end}}
             </tr>
         </thead>
         <tbody>
             {{if .Path}}
             <tr>
-                <td><a href="{{if eq (len (split .Path "/")) 1}}/{{else}}{{.Path | dirname}}/{{end}}">📁 ..</a></td>
+                <td><a href="{{.ParentURL}}">📁 ..</a></td>
                 <td><span class="dir-icon">📁</span> Directory</td>
-                <td>-</td>
-                <td>-</td>
+                {{if not .HideSize}}<td>-</td>{{end}}
+                {{if not .HideMTime}}<td>-</td>{{end}}
+                {{if .ChildCounts}}<td>-</td>{{end}}
+                {{if .Checksums}}<td>-</td>{{end}}
             </tr>
             {{end}}
             {{range .Files}}
-            <tr>
-                <td><a href="{{.URL}}">{{if .IsDir}}📁{{else}}📄{{end}} {{.Name}}</a></td>
+            {{if .Letter}}<tr class="letter"><th colspan="{{$.Columns}}">{{.Letter}}</th></tr>{{end}}
+            <tr class="entry" data-dir="{{if .IsDir}}1{{else}}0{{end}}" data-name="{{.Name}}"{{if not $.HideSize}} data-size="{{.Size}}"{{end}}{{if not $.HideMTime}} data-mtime="{{.ModTime.UnixMilli}}"{{end}}>
+                <td>{{if .URL}}<a href="{{.URL}}">{{if .Thumbnail}}<img class="thumb" src="{{.Thumbnail}}" alt="" loading="lazy">{{else if .IsDir}}📁{{else}}📄{{end}} {{.Name}}</a>{{else}}{{if .IsDir}}📁{{else}}📄{{end}} {{.Name}}{{end}}{{if .Preview}} <a class="preview" href="{{.Preview}}">preview</a>{{end}}</td>
                 <td>{{if .IsDir}}Directory{{else}}File{{end}}</td>
-                <td>{{if .IsDir}}-{{else}}{{.Size | formatBytes}}{{end}}</td>
-                <td>{{.ModTime.Format "2006-01-02 15:04"}}</td>
+                {{if not $.HideSize}}<td>{{if .IsDir}}-{{else}}{{.Size | formatBytes}}{{end}}</td>{{end}}
+                {{if $.HideMTime}}{{else if $.RelativeTime}}<td title="{{.ModTime.Format $.TimeFormat}}">{{timeAgo .ModTime $.Now}}</td>{{else}}<td>{{.ModTime.Format $.TimeFormat}}</td>{{end}}
+                {{if $.ChildCounts}}<td>{{if .IsDir}}{{or .Children "-"}}{{else}}-{{end}}</td>{{end}}
+                {{if $.Checksums}}<td>{{if .IsDir}}-{{else}}<a href="{{.URL}}?checksum=sha256">SHA-256</a>{{end}}</td>{{end}}
             </tr>
             {{end}}
         </tbody>
     </table>
+    <p class="summary">{{.FileCount}} file(s), {{.DirCount}} director{{if eq .DirCount 1}}y{{else}}ies{{end}}{{if not .HideSize}}, {{.TotalSize | formatBytes}} total{{end}}</p>
+    {{if .Total}}<p class="summary truncated">Showing the first {{.Shown}} of {{.Total}} entries. Use search or the type filters to find the rest.</p>{{end}}
+    {{if gt .Pages 1}}
+    <p class="pages">
+        {{if .PrevURL}}<a href="{{.PrevURL}}">← Previous</a>{{end}}
+        Page {{.Page}} of {{.Pages}}
+        {{if .NextURL}}<a href="{{.NextURL}}">Next →</a>{{end}}
+    </p>
+    {{end}}
+    {{with .Readme}}
+    <div class="readme">
+        <h2 class="name">{{.Name}}</h2>
+        <div class="markdown">
+{{.HTML}}
+        </div>
+        {{if .Truncated}}<p class="summary">README shortened; <a href="{{.URL}}">open the full file</a>.</p>{{end}}
+    </div>
+    {{end}}
+    {{if .ClientSort}}
+    <script>` + clientSortScript + `</script>
+    {{end}}
 </body>
 </html>`
 
 	// Create template with custom functions
 	t, err := template.New("listing").Funcs(template.FuncMap{
-		"formatBytes": func(bytes int64) string {
-			if bytes < 1024 {
-				return fmt.Sprintf("%d B", bytes)
-			} else if bytes < 1024*1024 {
-				return fmt.Sprintf("%.1f KB", float64(bytes)/1024)
-			} else {
-				return fmt.Sprintf("%.1f MB", float64(bytes)/(1024*1024))
-			}
-		},
-		"split": strings.Split,
-		"dirname": func(path string) string {
-			parts := strings.Split(path, "/")
-			if len(parts) <= 1 {
-				return ""
-			}
-			return strings.Join(parts[:len(parts)-1], "/")
-		},
+		"formatBytes": formatBytes,
+		"timeAgo":     timeAgo,
 	}).Parse(tmpl)
 	if err != nil {
 		return "", err
@@ -289,8 +2454,205 @@ func (fs *FileServer) generateDirectoryHTML(listing DirectoryListing) (string, e
 	return buf.String(), nil
 }
 
+// formatBytes renders a size for people, e.g. "1.5 KB".
+func formatBytes(bytes int64) string {
+	if bytes < 1024 {
+		return fmt.Sprintf("%d B", bytes)
+	} else if bytes < 1024*1024 {
+		return fmt.Sprintf("%.1f KB", float64(bytes)/1024)
+	} else {
+		return fmt.Sprintf("%.1f MB", float64(bytes)/(1024*1024))
+	}
+}
+
+// timeAgo describes t relative to now, e.g. "3 minutes ago", for
+// --relative-time listings. Anything older than a year gets its date.
+func timeAgo(t, now time.Time) string {
+	d := now.Sub(t)
+	if d < 0 {
+		return "in the future"
+	}
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit + " ago"
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	case d < 30*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d < 365*24*time.Hour:
+		return plural(int(d/(30*24*time.Hour)), "month")
+	}
+	return t.Format("2006-01-02")
+}
+
+// parseCacheExt parses --cache-ext values such as "html=0,js=3600" into a
+// map keyed by lowercase extension with a leading dot.
+func parseCacheExt(values []string) (map[string]int, error) {
+	byExt := make(map[string]int)
+	for _, value := range values {
+		for _, pair := range strings.Split(value, ",") {
+			ext, age, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			seconds, err := strconv.Atoi(strings.TrimSpace(age))
+			if !ok || ext == "" || err != nil || seconds < 0 {
+				return nil, fmt.Errorf("invalid entry %q: expected ext=seconds", pair)
+			}
+			ext = strings.ToLower(strings.TrimSpace(ext))
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			byExt[ext] = seconds
+		}
+	}
+	return byExt, nil
+}
+
+// cacheControl returns the Cache-Control value for filename, or "" when
+// caching isn't configured for it. Validators (ETag, Last-Modified) are
+// still sent, so clients can revalidate once max-age has passed.
+func (fs *FileServer) cacheControl(filename string) string {
+	maxAge, ok := fs.cacheMaxAgeByExt[strings.ToLower(filepath.Ext(filename))]
+	if !ok {
+		if fs.cacheMaxAge == 0 {
+			return ""
+		}
+		maxAge = fs.cacheMaxAge
+	}
+	return fmt.Sprintf("public, max-age=%d", maxAge)
+}
+
+// setAge reports how long a response has sat in one of the internal caches
+// (checksums, thumbnails, listing pages). Age is rounded up to whole seconds, so any cache
+// hit is told apart from a freshly generated response, which has no Age.
+func setAge(w http.ResponseWriter, cachedAt time.Time) {
+	age := int64(math.Ceil(time.Since(cachedAt).Seconds()))
+	if age < 1 {
+		age = 1
+	}
+	w.Header().Set("Age", strconv.FormatInt(age, 10))
+}
+
+// fileETag returns a strong validator for a file derived from its size and
+// modification time. It is strong so that If-Range can match it: a resumed
+// download only gets a partial response while both are unchanged.
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+}
+
+// listingETag returns a weak validator for a directory listing, derived
+// from the name, size and ModTime of every entry. Adding, removing or
+// changing any entry changes it. It is weak because the HTML is generated
+// and only equivalent, not byte-identical, across server versions.
+func listingETag(files []FileInfo) string {
+	h := fnv.New64a()
+	for _, f := range files {
+		fmt.Fprintf(h, "%s\x00%t\x00%d\x00%d\x00", f.Name, f.IsDir, f.Size, f.ModTime.UnixNano())
+	}
+	return fmt.Sprintf("W/\"%x-%x\"", h.Sum64(), len(files))
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag,
+// using the weak comparison RFC 9110 prescribes for it.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadName returns the filename for the Content-Disposition of
+// filePath: its base name, or the ?filename= the client asked for. Quotes
+// are dropped and a directory part is ignored; names with control
+// characters, CR and LF included, are rejected.
+func downloadName(r *http.Request, filePath string) (string, error) {
+	if !r.URL.Query().Has("filename") {
+		return filepath.Base(filePath), nil
+	}
+	name, ok := sanitizeFilename(strings.ReplaceAll(r.URL.Query().Get("filename"), `"`, ""))
+	if !ok {
+		return "", &requestError{http.StatusBadRequest, "Bad Request: invalid filename"}
+	}
+	return name, nil
+}
+
+// contentDisposition builds a Content-Disposition header value for filename.
+// Names that are not plain ASCII get an RFC 5987 filename* parameter next to
+// an ASCII-only filename fallback for clients that don't understand it.
+func contentDisposition(dispType, filename string) string {
+	var fallback strings.Builder
+	ascii := true
+	for _, r := range filename {
+		switch {
+		case r >= 0x80 || r < 0x20 || r == 0x7f:
+			ascii = false
+			fallback.WriteByte('_')
+		case r == '"' || r == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(r)
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+
+	value := fmt.Sprintf("%s; filename=\"%s\"", dispType, fallback.String())
+	if !ascii {
+		value += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+	return value
+}
+
+// encodeRFC5987 percent-encodes every byte of s outside the attr-char set.
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
+
+func isAttrChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
+
+// isTextMime reports whether a MIME type returned by getMimeType is textual.
+func isTextMime(mimeType string) bool {
+	switch mimeType {
+	case "application/javascript", "application/json", "image/svg+xml":
+		return true
+	}
+	return strings.HasPrefix(mimeType, "text/")
+}
+
 func getMimeType(filename string) string {
 	ext := strings.ToLower(filepath.Ext(filename))
+	if mimeType, ok := mimeOverrides[ext]; ok {
+		return mimeType
+	}
 	switch ext {
 	case ".html", ".htm":
 		return "text/html"
@@ -314,7 +2676,61 @@ func getMimeType(filename string) string {
 		return "text/plain"
 	case ".md":
 		return "text/markdown"
+	case ".csv":
+		return "text/csv"
+	case ".webp":
+		return "image/webp"
+	case ".mp4", ".m4v":
+		return "video/mp4"
+	case ".webm":
+		return "video/webm"
+	case ".mov":
+		return "video/quicktime"
+	case ".mkv":
+		return "video/x-matroska"
+	case ".mp3":
+		return "audio/mpeg"
+	case ".ogg", ".oga":
+		return "audio/ogg"
+	case ".wav":
+		return "audio/wav"
+	case ".flac":
+		return "audio/flac"
+	case ".m4a":
+		return "audio/mp4"
+	case ".zip":
+		return "application/zip"
+	case ".gz", ".tgz":
+		return "application/gzip"
+	case ".tar":
+		return "application/x-tar"
+	case ".bz2":
+		return "application/x-bzip2"
+	case ".xz":
+		return "application/x-xz"
+	case ".7z":
+		return "application/x-7z-compressed"
+	case ".rar":
+		return "application/x-rar-compressed"
+	case ".doc":
+		return "application/msword"
+	case ".docx":
+		return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	case ".xls":
+		return "application/vnd.ms-excel"
+	case ".xlsx":
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case ".ppt":
+		return "application/vnd.ms-powerpoint"
+	case ".pptx":
+		return "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	case ".odt":
+		return "application/vnd.oasis.opendocument.text"
+	case ".ods":
+		return "application/vnd.oasis.opendocument.spreadsheet"
+	case ".rtf":
+		return "application/rtf"
 	default:
-		return "application/octet-stream"
+		return defaultMimeType
 	}
 }
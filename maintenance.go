@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// maintenanceRetryAfter is what clients are told to wait while the server
+// is in maintenance mode.
+const maintenanceRetryAfter = 5 * time.Minute
+
+// maintenanceMode is toggled at runtime, by SIGUSR1 on Unix (see
+// watchMaintenanceSignal).
+var maintenanceMode atomic.Bool
+
+// toggleMaintenance flips maintenance mode and logs the new state.
+func toggleMaintenance() {
+	on := !maintenanceMode.Load()
+	maintenanceMode.Store(on)
+	if on {
+		log.Printf("Maintenance mode on: answering 503 until toggled off")
+	} else {
+		log.Printf("Maintenance mode off: serving again")
+	}
+}
+
+// withMaintenance answers every request with 503 and a Retry-After header
+// while maintenance mode is on, using fs's error pages. The listener stays
+// open, so clients get a clear answer instead of a refused connection.
+// withHealth is installed outside it, so /healthz keeps reporting.
+func withMaintenance(next http.Handler, fs *FileServer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !maintenanceMode.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(maintenanceRetryAfter.Seconds())))
+		fs.serveError(w, r, http.StatusServiceUnavailable, "Service Unavailable: down for maintenance, please try again later")
+	})
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// watchMaintenanceSignal does nothing where there is no SIGUSR1.
+func watchMaintenanceSignal() {}
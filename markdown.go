@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+const (
+	// maxCachedMarkdown bounds the rendered page cache; it is reset when
+	// full.
+	maxCachedMarkdown = 1000
+
+	// maxMarkdownSize is the largest file --render-markdown renders; bigger
+	// ones are served as they are.
+	maxMarkdownSize = 10 << 20
+)
+
+// markdown renders GitHub-flavored Markdown. Raw HTML in documents is
+// dropped (goldmark's default), so a served file can't inject scripts.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// markdownCSS styles rendered Markdown, on its own page and in listing
+// READMEs.
+const markdownCSS = `        .markdown { max-width: 860px; line-height: 1.5; }
+        .markdown pre { background-color: #f6f8fa; padding: 12px; overflow: auto; }
+        .markdown code { background-color: #f6f8fa; padding: 2px 4px; }
+        .markdown table { border-collapse: collapse; }
+        .markdown th, .markdown td { border: 1px solid #ddd; padding: 6px 12px; }
+        .markdown img { max-width: 100%; }
+`
+
+// markdownPageCSS is the stylesheet of rendered Markdown pages;
+// markdownCSP allows it by hash.
+const markdownPageCSS = `
+` + pageCSS + `
+` + markdownCSS + `
+        .source { color: #666; font-size: 0.9em; }
+    `
+
+var markdownTemplate = template.Must(template.New("markdown").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Title}}</title>
+    <style>` + markdownPageCSS + `</style>
+</head>
+<body>
+    <p class="source"><a href="{{.Source}}">View source</a></p>
+    <div class="markdown">
+{{.Body}}
+    </div>
+</body>
+</html>`))
+
+// markdownKey identifies one version of a Markdown file.
+type markdownKey struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// markdownCache remembers rendered pages, keyed like checksumCache so an
+// edited file is rendered again.
+type markdownCache struct {
+	mu      sync.Mutex
+	entries map[markdownKey][]byte
+}
+
+func (c *markdownCache) get(key markdownKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	page, ok := c.entries[key]
+	return page, ok
+}
+
+func (c *markdownCache) put(key markdownKey, page []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= maxCachedMarkdown {
+		c.entries = make(map[markdownKey][]byte)
+	}
+	c.entries[key] = page
+}
+
+// forget drops the pages rendered from path and from anything below it.
+func (c *markdownCache) forget(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if isWithin(key.path, path) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// isMarkdown reports whether filename is a Markdown document.
+func isMarkdown(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// wantsRenderedMarkdown reports whether --render-markdown should answer r
+// for a Markdown file with HTML: browsers asking for text/html get the
+// page, API clients and ?raw=true the file itself.
+func wantsRenderedMarkdown(r *http.Request, info os.FileInfo) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if !isMarkdown(info.Name()) || info.Size() > maxMarkdownSize || r.URL.Query().Get("raw") == "true" {
+		return false
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// serveMarkdown renders the Markdown file at filePath into an HTML page.
+func (fs *FileServer) serveMarkdown(w http.ResponseWriter, r *http.Request, filePath string, info os.FileInfo) {
+	key := markdownKey{path: filePath, size: info.Size(), modTime: info.ModTime()}
+	page, ok := fs.markdownPages.get(key)
+	if !ok {
+		source, err := os.ReadFile(filePath)
+		if err != nil {
+			fs.serveError(w, r, http.StatusInternalServerError, "Error reading file: "+err.Error())
+			return
+		}
+		var body bytes.Buffer
+		if err := markdown.Convert(source, &body); err != nil {
+			fs.serveError(w, r, http.StatusInternalServerError, "Error rendering Markdown: "+err.Error())
+			return
+		}
+		var buf bytes.Buffer
+		data := struct {
+			Title  string
+			Source string
+			Body   template.HTML
+		}{info.Name(), fs.baseURL + "/raw" + fs.rootRelative(filePath), template.HTML(body.String())}
+		if err := markdownTemplate.Execute(&buf, data); err != nil {
+			fs.serveError(w, r, http.StatusInternalServerError, "Error rendering Markdown: "+err.Error())
+			return
+		}
+		page = buf.Bytes()
+		fs.markdownPages.put(key, page)
+	}
+
+	// The same URL answers with Markdown for other clients
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", markdownCSP)
+	http.ServeContent(w, r, info.Name(), info.ModTime(), bytes.NewReader(page))
+}
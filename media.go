@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// mediaPageCSS is the stylesheet of --media-player pages; mediaCSP allows
+// it by hash.
+const mediaPageCSS = `
+` + pageCSS + `
+        video { max-width: 100%; max-height: 80vh; background-color: #000; }
+        audio { width: 100%; max-width: 640px; }
+        .source { color: #666; font-size: 0.9em; }
+    `
+
+var mediaCSP = pageCSP(mediaPageCSS, "")
+
+var mediaTemplate = template.Must(template.New("media").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Title}}</title>
+    <style>` + mediaPageCSS + `</style>
+</head>
+<body>
+    <h1>{{.Title}}</h1>
+    {{if eq .Group "video"}}<video controls preload="metadata" src="{{.Source}}"></video>
+    {{else}}<audio controls preload="metadata" src="{{.Source}}"></audio>{{end}}
+    <p class="source"><a href="{{.Source}}">Open the file itself</a></p>
+</body>
+</html>`))
+
+// wantsMediaPlayer reports whether a request for a file should get the
+// --media-player page: browsers asking for an audio or video file.
+// ?raw=true and /raw/, which the player itself plays from, get the bytes.
+func wantsMediaPlayer(r *http.Request, info os.FileInfo) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if group := fileTypeGroup(info.Name()); group != "audio" && group != "video" {
+		return false
+	}
+	return r.URL.Query().Get("raw") != "true" && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// serveMediaPlayer answers with a page playing the file at filePath in an
+// HTML5 player. The player fetches /raw/, which supports Range requests, so
+// seeking works.
+func (fs *FileServer) serveMediaPlayer(w http.ResponseWriter, r *http.Request, filePath string, info os.FileInfo) {
+	data := struct {
+		Title  string
+		Group  string
+		Source string
+	}{info.Name(), fileTypeGroup(info.Name()), (&url.URL{Path: fs.baseURL + "/raw" + fs.rootRelative(filePath)}).String()}
+
+	var page bytes.Buffer
+	if err := mediaTemplate.Execute(&page, data); err != nil {
+		fs.serveError(w, r, http.StatusInternalServerError, "Error rendering player: "+err.Error())
+		return
+	}
+
+	// The same URL answers with the file for other clients
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", mediaCSP)
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(page.Bytes()))
+}
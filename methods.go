@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// allowedMethods lists the methods the current configuration supports on
+// a path, for Allow headers; raw is set below the read-only /raw/ prefix.
+func (fs *FileServer) allowedMethods(raw bool) string {
+	methods := []string{http.MethodOptions, http.MethodGet, http.MethodHead}
+	if fs.writable && !raw {
+		methods = append(methods, http.MethodPut, http.MethodPost, "MOVE", http.MethodDelete)
+	}
+	if fs.webdav != nil && !raw {
+		methods = append(methods, "PROPFIND")
+		if fs.writable {
+			methods = append(methods, "PROPPATCH", "MKCOL", "COPY", "LOCK", "UNLOCK")
+		}
+	}
+	return strings.Join(methods, ", ")
+}
+
+// serveOptions answers OPTIONS with 204 and the supported methods. WebDAV
+// clients also learn the compliance classes they would have got from the
+// WebDAV handler.
+func (fs *FileServer) serveOptions(w http.ResponseWriter, r *http.Request, raw bool) {
+	w.Header().Set("Allow", fs.allowedMethods(raw))
+	if fs.webdav != nil {
+		w.Header().Set("DAV", "1, 2")
+		w.Header().Set("MS-Author-Via", "DAV")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
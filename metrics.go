@@ -0,0 +1,84 @@
+//go:build metrics
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsSupported = true
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests, by method and status code.",
+	}, []string{"method", "status"})
+
+	requestErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_request_errors_total",
+		Help: "Total number of HTTP requests answered with a 4xx or 5xx status.",
+	}, []string{"status"})
+
+	responseBytesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "http_response_bytes_total",
+		Help: "Total number of response body bytes sent.",
+	})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time spent serving HTTP requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestErrorsTotal, responseBytesTotal, requestDuration)
+}
+
+// newMetricsHandler returns the handler FileServer serves --metrics-path
+// with, behind the same client, ACL and link checks as files.
+func newMetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// metricMethods are the methods recorded under their own name; anything
+// else a client makes up is counted as OTHER, keeping the label set small.
+var metricMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+	http.MethodConnect: true, http.MethodTrace: true,
+	"MOVE": true, "COPY": true, "MKCOL": true, "PROPFIND": true, "PROPPATCH": true,
+	"LOCK": true, "UNLOCK": true,
+}
+
+func metricMethod(method string) string {
+	if metricMethods[method] {
+		return method
+	}
+	return "OTHER"
+}
+
+// withMetrics records request metrics for next.
+func withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newStatusRecorder(w)
+		// Deferred, so aborted requests are counted too
+		defer func() {
+			status := strconv.Itoa(rec.status)
+			method := metricMethod(r.Method)
+			requestsTotal.WithLabelValues(method, status).Inc()
+			if rec.status >= 400 {
+				requestErrorsTotal.WithLabelValues(status).Inc()
+			}
+			responseBytesTotal.Add(float64(rec.bytes))
+			requestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,17 @@
+//go:build !metrics
+
+package main
+
+import "net/http"
+
+// metricsSupported is false unless the binary is built with -tags metrics,
+// which keeps the Prometheus client out of default builds.
+const metricsSupported = false
+
+func newMetricsHandler() http.Handler {
+	return nil
+}
+
+func withMetrics(next http.Handler) http.Handler {
+	return next
+}
@@ -0,0 +1,57 @@
+//go:build metrics
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetricsEndpointFollowsAccessRules(t *testing.T) {
+	dir := t.TempDir()
+	fs := newTestServer(dir)
+	fs.metrics = newMetricsHandler()
+	fs.metricsPath = "/metrics"
+
+	if w := doRequest(fs, http.MethodGet, "/metrics", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "go_goroutines") {
+		t.Fatalf("open server: status %d, want 200 with metrics", w.Code)
+	}
+
+	_, testNet, _ := net.ParseCIDR("192.0.2.0/24") // httptest requests come from 192.0.2.1
+	fs.denyNets = []*net.IPNet{testNet}
+	if w := doRequest(fs, http.MethodGet, "/metrics", nil); w.Code != http.StatusForbidden {
+		t.Errorf("--deny: status %d, want 403", w.Code)
+	}
+	fs.denyNets = nil
+
+	acl := &accessList{users: map[string]string{"prom": "secret"}, rules: []aclRule{{pattern: "/metrics", users: []string{"prom"}}}}
+	fs.acl.Store(acl)
+	if w := doRequest(fs, http.MethodGet, "/metrics", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("ACL without credentials: status %d, want 401", w.Code)
+	}
+	creds := "Basic cHJvbTpzZWNyZXQ=" // prom:secret
+	if w := doRequest(fs, http.MethodGet, "/metrics", nil, "Authorization", creds); w.Code != http.StatusOK {
+		t.Errorf("ACL with credentials: status %d, want 200", w.Code)
+	}
+	fs.acl.Store(nil)
+
+	fs.baseURL = "/files"
+	if w := doRequest(fs, http.MethodGet, "/metrics", nil); w.Code != http.StatusNotFound {
+		t.Errorf("outside --base-url: status %d, want 404", w.Code)
+	}
+	if w := doRequest(fs, http.MethodGet, "/files/metrics", nil); w.Code != http.StatusOK {
+		t.Errorf("below --base-url: status %d, want 200", w.Code)
+	}
+}
+
+func TestMetricMethodBoundsLabels(t *testing.T) {
+	for method, want := range map[string]string{
+		"GET": "GET", "PROPFIND": "PROPFIND", "BREW": "OTHER", "get": "OTHER", strings.Repeat("X", 100): "OTHER",
+	} {
+		if got := metricMethod(method); got != want {
+			t.Errorf("metricMethod(%q) = %q, want %q", method, got, want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// statusRecorder wraps a ResponseWriter to remember the status code and the
+// number of body bytes written, for use by logging and metrics middleware.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// withFaults fails roughly rate (0..1) of all requests with status, for
+// testing how clients cope with errors. The RNG is seeded with seed so a
+// run can be reproduced.
+func withFaults(next http.Handler, rate float64, status int, seed int64) http.Handler {
+	var mu sync.Mutex
+	rng := rand.New(rand.NewSource(seed))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fail := rng.Float64() < rate
+		mu.Unlock()
+
+		if fail {
+			http.Error(w, "Injected fault: "+http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// healthPath is where withHealth answers liveness checks.
+const healthPath = "/healthz"
+
+// withHealth answers GET and HEAD on /healthz with 200 before any other
+// handling, so health checks are not subject to access rules or injected
+// faults. HEAD gets the same headers without a body.
+func withHealth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != healthPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Length", "3")
+			w.WriteHeader(http.StatusOK)
+			if r.Method == http.MethodGet {
+				w.Write([]byte("ok\n"))
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// requestIDHeader carries the request ID in both directions.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds incoming IDs that are reused as-is.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// withRequestID tags every request with an ID, reusing a sane incoming
+// X-Request-ID (e.g. from a proxy) or generating a random one. The ID is
+// echoed in the response and available to later handlers via requestID.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID assigned by withRequestID, or "" without one.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	var b [16]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID accepts non-empty printable ASCII IDs without spaces, so
+// a client can't inject anything odd into headers or log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseHeaders parses repeatable --header "Name: Value" flags.
+func parseHeaders(values []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, value := range values {
+		name, content, ok := strings.Cut(value, ":")
+		name, content = strings.TrimSpace(name), strings.TrimSpace(content)
+		if !ok || !httpguts.ValidHeaderFieldName(name) {
+			return nil, fmt.Errorf("invalid header %q: expected \"Name: Value\"", value)
+		}
+		if !httpguts.ValidHeaderFieldValue(content) {
+			return nil, fmt.Errorf("invalid value for header %q", name)
+		}
+		headers.Add(name, content)
+	}
+	return headers, nil
+}
+
+// withHeaders adds the --header values to every response before the
+// handler runs, so a header the handler sets itself takes precedence.
+func withHeaders(next http.Handler, headers http.Header) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range headers {
+			w.Header()[name] = append(w.Header()[name], values...)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withServerName sets the Server header of every response to name, or
+// drops it when name is empty. It runs inside withHeaders, so it also wins
+// over a Server given with --header.
+func withServerName(next http.Handler, name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name == "" {
+			w.Header().Del("Server")
+		} else {
+			w.Header().Set("Server", name)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// busyRetryAfter is the Retry-After, in seconds, sent when
+// withConcurrencyLimit turns a request away.
+const busyRetryAfter = "5"
+
+// withConcurrencyLimit lets at most limit requests run at once. A request
+// over the limit waits up to wait for a slot, giving up early if the client
+// goes away, and then gets 503 with Retry-After; with wait 0 it is refused
+// right away. The slot is released by a deferred call, so a panicking or
+// abandoned handler can't leak it.
+func withConcurrencyLimit(next http.Handler, limit int, wait time.Duration) http.Handler {
+	slots := make(chan struct{}, limit)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+		default:
+			if !acquireSlot(r, slots, wait) {
+				w.Header().Set("Retry-After", busyRetryAfter)
+				http.Error(w, "Service Unavailable: too many concurrent requests", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		defer func() { <-slots }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquireSlot waits up to wait for room in slots.
+func acquireSlot(r *http.Request, slots chan struct{}, wait time.Duration) bool {
+	if wait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
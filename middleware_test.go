@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFaultInjection(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a"})
+	fs := newTestServer(dir)
+
+	for _, tc := range []struct {
+		rate   float64
+		status int
+	}{
+		{1.0, http.StatusServiceUnavailable},
+		{1.0, http.StatusInternalServerError},
+		{0.0, http.StatusOK},
+	} {
+		handler := withFaults(fs, tc.rate, tc.status, 1)
+		for i := 0; i < 100; i++ {
+			if w := doRequest(handler, http.MethodGet, "/a.txt", nil); w.Code != tc.status {
+				t.Fatalf("rate %v: request %d got %d, want %d", tc.rate, i, w.Code, tc.status)
+			}
+		}
+	}
+
+	// The same seed fails the same requests
+	pattern := func() (failed []bool) {
+		handler := withFaults(fs, 0.5, http.StatusServiceUnavailable, 42)
+		for i := 0; i < 50; i++ {
+			failed = append(failed, doRequest(handler, http.MethodGet, "/a.txt", nil).Code != http.StatusOK)
+		}
+		return failed
+	}
+	first, second := pattern(), pattern()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("request %d failed in one run with seed 42 but not the other", i)
+		}
+	}
+}
+
+func TestHealthEndpoint(t *testing.T) {
+	// Reached before the access rules and injected faults
+	handler := withHealth(withFaults(newTestServer(t.TempDir()), 1.0, http.StatusServiceUnavailable, 1))
+
+	get := doRequest(handler, http.MethodGet, healthPath, nil)
+	if get.Code != http.StatusOK || get.Body.String() != "ok\n" {
+		t.Errorf("GET: status %d, body %q", get.Code, get.Body)
+	}
+	head := doRequest(handler, http.MethodHead, healthPath, nil)
+	if head.Code != http.StatusOK || head.Body.Len() != 0 {
+		t.Errorf("HEAD: status %d with a %d byte body, want 200 and none", head.Code, head.Body.Len())
+	}
+	if head.Header().Get("Content-Length") != get.Header().Get("Content-Length") {
+		t.Errorf("HEAD Content-Length %q, GET %q", head.Header().Get("Content-Length"), get.Header().Get("Content-Length"))
+	}
+	if w := doRequest(handler, http.MethodPost, healthPath, nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", w.Code)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// mimeOverrides maps lowercase extensions (with the dot) to the MIME types
+// configured via --mime and --mime-file. getMimeType consults it first.
+var mimeOverrides = map[string]string{}
+
+// defaultMimeType is what getMimeType returns for unknown extensions; it
+// can be changed with --default-mime.
+var defaultMimeType = "application/octet-stream"
+
+// setDefaultMime validates and applies a --default-mime value.
+func setDefaultMime(value string) error {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		return fmt.Errorf("invalid MIME type %q: expected type/subtype", value)
+	}
+	defaultMimeType = value
+	return nil
+}
+
+// addMimeOverride parses a --mime value of the form ".ext=type".
+func addMimeOverride(value string) error {
+	ext, mimeType, ok := strings.Cut(value, "=")
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	mimeType = strings.TrimSpace(mimeType)
+	if !ok || ext == "" || !strings.Contains(mimeType, "/") {
+		return fmt.Errorf("invalid MIME override %q: expected .ext=type/subtype", value)
+	}
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	mimeOverrides[ext] = mimeType
+	return nil
+}
+
+// loadMimeFile reads an Apache-style mime.types file: a MIME type followed
+// by the extensions that map to it, with # starting a comment.
+func loadMimeFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if !strings.Contains(fields[0], "/") {
+			return fmt.Errorf("%s:%d: invalid MIME type %q", path, lineNo, fields[0])
+		}
+		for _, ext := range fields[1:] {
+			mimeOverrides["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = fields[0]
+		}
+	}
+	return scanner.Err()
+}
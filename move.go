@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// handleMove renames the file or directory at srcPath to the Destination
+// of a MOVE request in writable mode, the WebDAV method without needing
+// --webdav. Both paths go through the same containment and access checks
+// as a request path and must be writable under --write-prefix. The
+// destination's folder has to exist, and an existing file there is only
+// replaced with Overwrite: T. It answers 201 for a new name and 204 for a
+// replaced file.
+func (fs *FileServer) handleMove(w http.ResponseWriter, r *http.Request, srcPath string) {
+	if r.Header.Get("Destination") == "" {
+		fs.serveError(w, r, http.StatusBadRequest, "Bad Request: missing Destination")
+		return
+	}
+	destination, err := fs.destinationURLPath(r)
+	if err != nil {
+		fs.writeRequestError(w, r, err)
+		return
+	}
+	dstPath, err := fs.resolvePath(strings.TrimPrefix(destination, "/"))
+	if err == nil {
+		err = fs.checkAccess(r, dstPath)
+	}
+	if err != nil {
+		fs.writeRequestError(w, r, err)
+		return
+	}
+	if !fs.writeAllowed(srcPath) || !fs.writeAllowed(dstPath) {
+		fs.writeForbidden(w, r)
+		return
+	}
+
+	srcInfo, err := os.Lstat(srcPath)
+	if err != nil {
+		fs.serveError(w, r, http.StatusNotFound, "Not Found")
+		return
+	}
+	switch {
+	case srcPath == fs.servePath:
+		fs.serveError(w, r, http.StatusForbidden, "Forbidden: Cannot move the root folder")
+		return
+	case dstPath == srcPath:
+		fs.serveError(w, r, http.StatusForbidden, "Forbidden: Source and destination are the same")
+		return
+	case srcInfo.IsDir() && isWithin(dstPath, srcPath):
+		fs.serveError(w, r, http.StatusConflict, "Conflict: Cannot move a folder into itself")
+		return
+	}
+	if info, err := os.Stat(filepath.Dir(dstPath)); err != nil || !info.IsDir() {
+		fs.serveError(w, r, http.StatusConflict, "Conflict: Destination folder does not exist")
+		return
+	}
+
+	// Uploads to the destination wait for the move and vice versa
+	unlock := fs.lockUploadPath(dstPath)
+	defer unlock()
+
+	dstInfo, err := os.Lstat(dstPath)
+	exists := err == nil
+	if exists {
+		if r.Header.Get("Overwrite") != "T" {
+			fs.serveError(w, r, http.StatusConflict, "Conflict: Destination exists (send Overwrite: T to replace it)")
+			return
+		}
+		if dstInfo.IsDir() || srcInfo.IsDir() {
+			fs.serveError(w, r, http.StatusConflict, "Conflict: Only a file can replace a file")
+			return
+		}
+	}
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		log.Printf("Error moving %s to %s: %v", srcPath, dstPath, err)
+		fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error moving file: %v", err))
+		return
+	}
+
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
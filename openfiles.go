@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// errOpenFileLimit is returned by openFile when all --max-open-files
+// slots are taken.
+var errOpenFileLimit = errors.New("--max-open-files reached")
+
+// outOfFilesRetryAfter is the Retry-After, in seconds, sent with the 503
+// for a file that couldn't be opened for lack of file descriptors.
+const outOfFilesRetryAfter = "2"
+
+// openedFile is a file from openFile.
+type openedFile interface {
+	io.ReadSeekCloser
+	Stat() (os.FileInfo, error)
+}
+
+// limitedFile frees its --max-open-files slot when closed.
+type limitedFile struct {
+	*os.File
+	release sync.Once
+	slots   chan struct{}
+}
+
+func (f *limitedFile) Close() error {
+	err := f.File.Close()
+	f.release.Do(func() { <-f.slots })
+	return err
+}
+
+// openFile opens a file to serve it. With --max-open-files at most that
+// many are open at once, and past that errOpenFileLimit comes back right
+// away rather than the request waiting for a slot. Such files hide the
+// *os.File from net/http, so they are copied instead of sent with
+// sendfile(2).
+func (fs *FileServer) openFile(path string) (openedFile, error) {
+	if fs.openSlots == nil {
+		file, err := os.Open(path)
+		if err != nil {
+			// A nil *os.File in the interface would not be nil
+			return nil, err
+		}
+		return file, nil
+	}
+	select {
+	case fs.openSlots <- struct{}{}:
+	default:
+		return nil, errOpenFileLimit
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		<-fs.openSlots
+		return nil, err
+	}
+	return &limitedFile{File: file, slots: fs.openSlots}, nil
+}
+
+// isOutOfFiles reports whether err means the process (or, with
+// --max-open-files, the server) has no file handles left.
+func isOutOfFiles(err error) bool {
+	return errors.Is(err, errOpenFileLimit) || errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// lastOutOfFilesLog is the Unix time of the last warning about running
+// out of file handles, so a busy server logs it once a second at most.
+var lastOutOfFilesLog atomic.Int64
+
+// serveReadError answers for a file that couldn't be opened or read: 503
+// with Retry-After when the server ran out of file handles, which a retry
+// may well get past, or 500 otherwise.
+func (fs *FileServer) serveReadError(w http.ResponseWriter, r *http.Request, err error) {
+	if !isOutOfFiles(err) {
+		fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error reading file: %v", err))
+		return
+	}
+	now := time.Now().Unix()
+	if last := lastOutOfFilesLog.Load(); now > last && lastOutOfFilesLog.CompareAndSwap(last, now) {
+		if errors.Is(err, errOpenFileLimit) {
+			log.Printf("Warning: answering 503, all --max-open-files handles are in use")
+		} else {
+			log.Printf("Warning: answering 503, out of file descriptors (%v); raise the open file limit (ulimit -n) or set --max-open-files below it", err)
+		}
+	}
+	w.Header().Set("Retry-After", outOfFilesRetryAfter)
+	fs.serveError(w, r, http.StatusServiceUnavailable, "Service Unavailable: too many open files, try again shortly")
+}
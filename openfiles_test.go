@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestOpenFileLimitAnswers503(t *testing.T) {
+	logged := captureLog(t)
+	lastOutOfFilesLog.Store(0)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a"})
+	fs := newTestServer(dir)
+	fs.openSlots = make(chan struct{}, 1)
+
+	fs.openSlots <- struct{}{} // another download holds the only handle
+	w := doRequest(fs, http.MethodGet, "/a.txt", nil)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != outOfFilesRetryAfter {
+		t.Errorf("all handles in use: status %d, Retry-After %q; want 503 and %s", w.Code, w.Header().Get("Retry-After"), outOfFilesRetryAfter)
+	}
+	if !strings.Contains(logged.String(), "--max-open-files handles are in use") {
+		t.Errorf("no warning logged, got %q", logged.String())
+	}
+
+	<-fs.openSlots
+	if w := doRequest(fs, http.MethodGet, "/a.txt", nil); w.Code != http.StatusOK {
+		t.Errorf("free handle: status %d", w.Code)
+	}
+	if len(fs.openSlots) != 0 {
+		t.Error("the download didn't give its handle back")
+	}
+}
+
+func TestOutOfDescriptorsAnswers503(t *testing.T) {
+	logged := captureLog(t)
+	lastOutOfFilesLog.Store(0)
+	fs := newTestServer(t.TempDir())
+	for _, tc := range []struct {
+		err    error
+		status int
+	}{
+		{&os.PathError{Op: "open", Path: "a.txt", Err: syscall.EMFILE}, http.StatusServiceUnavailable},
+		{&os.PathError{Op: "open", Path: "a.txt", Err: syscall.ENFILE}, http.StatusServiceUnavailable},
+		{errors.New("disk on fire"), http.StatusInternalServerError},
+	} {
+		w := httptest.NewRecorder()
+		fs.serveReadError(w, httptest.NewRequest(http.MethodGet, "/a.txt", nil), tc.err)
+		if w.Code != tc.status {
+			t.Errorf("%v: status %d, want %d", tc.err, w.Code, tc.status)
+		}
+	}
+	if !strings.Contains(logged.String(), "out of file descriptors") || !strings.Contains(logged.String(), "ulimit -n") {
+		t.Errorf("no out of file descriptors warning, got %q", logged.String())
+	}
+}
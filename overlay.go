@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// parseOverlays validates the --overlay folders and returns their absolute
+// paths in flag order. Roots may not be nested in each other, which would
+// give the same file two root-relative paths and let path rules be
+// sidestepped.
+func parseOverlays(values []string, servePath string) ([]string, error) {
+	roots := []string{servePath}
+	var overlays []string
+	for _, value := range values {
+		root, err := filepath.Abs(value)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a folder", root)
+		}
+		for _, other := range roots {
+			if isWithin(root, other) || isWithin(other, root) {
+				return nil, fmt.Errorf("%s overlaps %s", root, other)
+			}
+		}
+		roots = append(roots, root)
+		overlays = append(overlays, root)
+	}
+	return overlays, nil
+}
+
+// isWithin reports whether path is root or below it.
+func isWithin(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// roots returns the serve root followed by the overlays, lowest precedence
+// first.
+func (fs *FileServer) roots() []string {
+	return append([]string{fs.servePath}, fs.overlays...)
+}
+
+// locate returns where the file with the root-relative path rel lives: in
+// the last overlay that has it, else under the serve root, whether or not
+// it exists there. Each candidate is checked to stay inside its own root.
+func (fs *FileServer) locate(rel string) string {
+	for i := len(fs.overlays) - 1; i >= 0; i-- {
+		candidate := filepath.Join(fs.overlays[i], filepath.FromSlash(rel))
+		if !isWithin(candidate, fs.overlays[i]) {
+			continue
+		}
+		if _, err := os.Lstat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return filepath.Join(fs.servePath, filepath.FromSlash(rel))
+}
+
+// dirEntry is a directory entry together with the path it was read at,
+// which with overlays may be in any root.
+type dirEntry struct {
+	os.DirEntry
+	path string
+}
+
+// readDir lists the directory at dirPath, in the archive when serving one.
+// With overlays it is the union of the same directory in every root, an
+// entry in a later overlay replacing one of the same name below it. It
+// fails only if no root has the directory.
+func (fs *FileServer) readDir(dirPath string) ([]dirEntry, error) {
+	if fs.archive != nil {
+		nodes, err := fs.archive.readDir(fs.rootRelative(dirPath))
+		entries := make([]dirEntry, len(nodes))
+		for i, node := range nodes {
+			entries[i] = dirEntry{DirEntry: node, path: filepath.Join(dirPath, node.name)}
+		}
+		return entries, err
+	}
+
+	dirs := []string{dirPath}
+	if len(fs.overlays) > 0 {
+		rel := fs.rootRelative(dirPath)
+		dirs = dirs[:0]
+		for _, root := range fs.roots() {
+			dirs = append(dirs, filepath.Join(root, filepath.FromSlash(rel)))
+		}
+	}
+
+	byName := make(map[string]dirEntry)
+	var firstErr error
+	found := false
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if firstErr == nil && !os.IsNotExist(err) {
+				firstErr = err
+			}
+			continue
+		}
+		found = true
+		for _, entry := range entries {
+			byName[entry.Name()] = dirEntry{DirEntry: entry, path: filepath.Join(dir, entry.Name())}
+		}
+	}
+	if !found {
+		if firstErr == nil {
+			firstErr = os.ErrNotExist
+		}
+		return nil, firstErr
+	}
+
+	entries := make([]dirEntry, 0, len(byName))
+	for _, entry := range byName {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"unicode/utf8"
+)
+
+// wantsPreview reports whether r asks for a --preview-size peek at a file.
+func (fs *FileServer) wantsPreview(r *http.Request, info os.FileInfo) bool {
+	return fs.previewSize > 0 && r.URL.Query().Get("preview") == "true" && info.Mode().IsRegular()
+}
+
+// isPreviewable reports whether the listing offers a preview link for
+// filename. It only goes by the name; servePreview checks the content.
+func isPreviewable(filename string) bool {
+	return isTextMime(getMimeType(filename)) || isSourceFile(filename)
+}
+
+// servePreview answers ?preview=true with the first --preview-size bytes of
+// the file at filePath as plain text, followed by a note when the file is
+// longer. Content that doesn't look like UTF-8 text gets 415 instead of a
+// page of binary.
+func (fs *FileServer) servePreview(w http.ResponseWriter, r *http.Request, filePath string) {
+	content, info, err := fs.openContent(filePath)
+	if err != nil {
+		fs.serveReadError(w, r, err)
+		return
+	}
+	if closer, ok := content.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	text, err := io.ReadAll(io.LimitReader(content, fs.previewSize))
+	if err != nil {
+		fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error reading file: %v", err))
+		return
+	}
+	truncated := info.Size() > int64(len(text))
+	if truncated {
+		// Don't end on half a character
+		for i := 0; i < utf8.UTFMax && len(text) > 0 && !utf8.Valid(text); i++ {
+			text = text[:len(text)-1]
+		}
+	}
+	if bytes.IndexByte(text, 0) >= 0 || !utf8.Valid(text) {
+		fs.serveError(w, r, http.StatusUnsupportedMediaType, "Unsupported Media Type: no preview for binary files")
+		return
+	}
+
+	var body bytes.Buffer
+	body.Write(text)
+	if truncated {
+		fmt.Fprintf(&body, "\n\n[Preview truncated: first %s of %s. Download the whole file from %s]\n",
+			formatBytes(int64(len(text))), formatBytes(info.Size()), (&url.URL{Path: fs.baseURL + fs.rootRelative(filePath)}).String())
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", contentDisposition("inline", filepath.Base(filePath)))
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	if r.Method != http.MethodHead {
+		w.Write(body.Bytes())
+	}
+}
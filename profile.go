@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+)
+
+// startProfiling serves the net/http/pprof handlers for --profile under
+// /debug/pprof/ on 127.0.0.1:port, a listener of their own so profiles are
+// never reachable through the public address. The port is bound before
+// returning, so a conflict fails at startup.
+func startProfiling(port int) (string, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return "", err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	// No write timeout: CPU profiles and traces stream for ?seconds=
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: *headerTimeout}
+	go server.Serve(listener)
+	return "http://" + listener.Addr().String() + "/debug/pprof/", nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// maxReadmeSize is how much of a README --show-readme renders below a
+// listing; longer ones are cut off with a link to the whole file.
+const maxReadmeSize = 64 << 10
+
+// readmeNames are the files --show-readme looks for, in order of
+// preference. Names match case-insensitively.
+var readmeNames = []string{"README.md", "README.txt"}
+
+// Readme is a directory's README as shown below its listing.
+type Readme struct {
+	Name      string
+	URL       string
+	HTML      template.HTML
+	Truncated bool
+}
+
+// readmeRank returns the position of name in readmeNames, or -1 if it isn't
+// a README.
+func readmeRank(name string) int {
+	for i, readme := range readmeNames {
+		if strings.EqualFold(name, readme) {
+			return i
+		}
+	}
+	return -1
+}
+
+// renderReadme reads up to maxReadmeSize of the README at filePath and
+// renders it: Markdown to HTML, anything else as preformatted text. Errors
+// leave the listing without a README rather than failing it.
+func (fs *FileServer) renderReadme(filePath, name, url string) *Readme {
+	content, info, err := fs.openContent(filePath)
+	if err != nil {
+		return nil
+	}
+	if closer, ok := content.(io.Closer); ok {
+		defer closer.Close()
+	}
+	readme := &Readme{Name: name, URL: url, Truncated: info.Size() > maxReadmeSize}
+
+	key := markdownKey{path: filePath, size: info.Size(), modTime: info.ModTime()}
+	if page, ok := fs.readmePages.get(key); ok {
+		readme.HTML = template.HTML(page)
+		return readme
+	}
+	source, err := io.ReadAll(io.LimitReader(content, maxReadmeSize))
+	if err != nil {
+		return nil
+	}
+
+	var body bytes.Buffer
+	if isMarkdown(name) {
+		if err := markdown.Convert(source, &body); err != nil {
+			return nil
+		}
+	} else {
+		body.WriteString("<pre>")
+		template.HTMLEscape(&body, source)
+		body.WriteString("</pre>")
+	}
+	fs.readmePages.put(key, body.Bytes())
+	readme.HTML = template.HTML(body.String())
+	return readme
+}
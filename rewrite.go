@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxRewritePasses bounds how often the rule set is re-applied to a path,
+// so rules that feed into each other can't loop forever.
+const maxRewritePasses = 10
+
+// rewriteRule maps request paths starting with (or matching) from onto to.
+// Rules whose from part starts with "^" are regular expressions and may
+// reference capture groups in to as $1, ${name}, and so on.
+type rewriteRule struct {
+	prefix string
+	re     *regexp.Regexp
+	to     string
+}
+
+// parseRewriteRule parses a "from=to" rule as given to --rewrite.
+func parseRewriteRule(s string) (rewriteRule, error) {
+	from, to, ok := strings.Cut(s, "=")
+	if !ok || from == "" {
+		return rewriteRule{}, fmt.Errorf("invalid rewrite rule %q: expected from=to", s)
+	}
+	if strings.HasPrefix(from, "^") {
+		re, err := regexp.Compile(from)
+		if err != nil {
+			return rewriteRule{}, fmt.Errorf("invalid rewrite rule %q: %v", s, err)
+		}
+		return rewriteRule{re: re, to: to}, nil
+	}
+	return rewriteRule{prefix: from, to: to}, nil
+}
+
+func (rule rewriteRule) apply(path string) string {
+	if rule.re != nil {
+		return rule.re.ReplaceAllString(path, rule.to)
+	}
+	if strings.HasPrefix(path, rule.prefix) {
+		return rule.to + strings.TrimPrefix(path, rule.prefix)
+	}
+	return path
+}
+
+// rewritePath applies rules to path until it stops changing. It fails if
+// the path is still changing after maxRewritePasses passes.
+func rewritePath(rules []rewriteRule, path string) (string, error) {
+	for pass := 0; pass < maxRewritePasses; pass++ {
+		next := path
+		for _, rule := range rules {
+			next = rule.apply(next)
+		}
+		if next == path {
+			return path, nil
+		}
+		path = next
+	}
+	return "", fmt.Errorf("rewrite rules did not settle after %d passes", maxRewritePasses)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func newRewriteServer(t *testing.T, dir string, rules ...string) *FileServer {
+	t.Helper()
+	fs := newTestServer(dir)
+	for _, s := range rules {
+		rule, err := parseRewriteRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs.rewrites = append(fs.rewrites, rule)
+	}
+	return fs
+}
+
+func TestRewriteServesNewLocation(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"new/x.txt": "moved", "docs/v2/guide.md": "guide"})
+	fs := newRewriteServer(t, dir, "/old/=/new/", `^/docs/latest/(.*)$=/docs/v2/$1`)
+
+	for target, want := range map[string]string{
+		"/old/x.txt":            "moved",
+		"/new/x.txt":            "moved",
+		"/docs/latest/guide.md": "guide",
+	} {
+		if w := doRequest(fs, http.MethodGet, target, nil); w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("GET %s: status %d, body %q; want %q", target, w.Code, w.Body, want)
+		}
+	}
+}
+
+func TestRewriteLoopsAndEscapes(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "root")
+	writeFiles(t, parent, map[string]string{"secret.txt": "outside", "root/private/p.txt": "p"})
+	fs := newRewriteServer(t, dir, "/a/=/a/a/", "/up/=/../", "/pub/=/private/")
+	fs.acl.Store(&accessList{
+		users: map[string]string{"alice": "pw"},
+		rules: []aclRule{{pattern: "/private", users: []string{"alice"}}},
+	})
+
+	for target, want := range map[string]int{
+		"/a/x":           http.StatusInternalServerError, // the rules never settle
+		"/up/secret.txt": http.StatusForbidden,           // rewritten paths are still contained
+		"/pub/p.txt":     http.StatusUnauthorized,        // and access checked as rewritten
+	} {
+		w := doRequest(fs, http.MethodGet, target, nil)
+		if w.Code != want {
+			t.Errorf("GET %s: status %d, want %d", target, w.Code, want)
+		}
+		if w.Body.String() == "outside" || w.Body.String() == "p" {
+			t.Errorf("GET %s leaked %q", target, w.Body)
+		}
+	}
+	if _, err := parseRewriteRule("no-equals"); err == nil {
+		t.Error("a rule without = was accepted")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// defaultSendfileProxies are the peers trusted to act on --sendfile-header
+// when no --sendfile-proxy is given: a reverse proxy on the same host.
+var defaultSendfileProxies = []string{"127.0.0.0/8", "::1"}
+
+// parseSendfileHeader validates a --sendfile-header value and returns its
+// canonical spelling.
+func parseSendfileHeader(value string) (string, error) {
+	switch {
+	case strings.EqualFold(value, "X-Accel-Redirect"):
+		return "X-Accel-Redirect", nil
+	case strings.EqualFold(value, "X-Sendfile"):
+		return "X-Sendfile", nil
+	}
+	return "", fmt.Errorf("unsupported header %q (want X-Accel-Redirect or X-Sendfile)", value)
+}
+
+// offloadFile hands the body of filePath to the reverse proxy in front of
+// the server instead of copying it: the --sendfile-header names the file and
+// the response itself is empty. nginx's X-Accel-Redirect takes a URI below
+// the internal --sendfile-prefix location; X-Sendfile (Apache, lighttpd)
+// takes the absolute file path. It reports false, and writes nothing, when
+// the file should be sent normally: offloading is off, the direct peer isn't
+// a trusted proxy, or the file isn't a plain file below the serve root.
+func (fs *FileServer) offloadFile(w http.ResponseWriter, r *http.Request, filePath string) bool {
+	if fs.sendfileHeader == "" || fs.archive != nil || !isWithin(filePath, fs.servePath) {
+		return false
+	}
+	// The proxy is the peer itself, never an address it forwards for
+	peer := clientIP(r, false)
+	if peer == nil || !containsIP(fs.sendfileNets, peer) {
+		return false
+	}
+
+	target := filePath
+	if fs.sendfileHeader == "X-Accel-Redirect" {
+		target = (&url.URL{Path: path.Join(fs.sendfilePrefix, fs.rootRelative(filePath))}).EscapedPath()
+	}
+	fs.tracef(r, "offloading %s to the proxy with %s", fs.rootRelative(filePath), fs.sendfileHeader)
+	// The proxy computes these for the bytes it sends
+	w.Header().Del("ETag")
+	w.Header().Set(fs.sendfileHeader, target)
+	w.WriteHeader(http.StatusOK)
+	return true
+}
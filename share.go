@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+)
+
+// shareSignature signs a --share-secret link to rel, a root-relative path
+// as returned by rootRelative, valid until the Unix time exp.
+func shareSignature(secret []byte, rel string, exp int64) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(rel + "\x00" + strconv.FormatInt(exp, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// shareLinkValid reports whether a request for rel carries a valid,
+// unexpired ?exp=&sig= pair. Without --share-secret every request passes.
+func (fs *FileServer) shareLinkValid(r *http.Request, rel string) bool {
+	if fs.shareSecret == nil {
+		return true
+	}
+	query := r.URL.Query()
+	exp, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(query.Get("sig")), []byte(shareSignature(fs.shareSecret, rel, exp)))
+}
+
+// signedPath returns the escaped URL path and query of a --share-secret
+// link to name, below the root, that expires after ttl.
+func signedPath(secret []byte, name string, ttl time.Duration) string {
+	rel := path.Clean("/" + name)
+	exp := time.Now().Add(ttl).Unix()
+	query := url.Values{}
+	query.Set("exp", strconv.FormatInt(exp, 10))
+	query.Set("sig", shareSignature(secret, rel, exp))
+	return (&url.URL{Path: rel}).String() + "?" + query.Encode()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShareLinks(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"docs/report.pdf": "report", "docs/other.pdf": "other"})
+	fs := newTestServer(dir)
+	fs.shareSecret = []byte("secret")
+
+	link := signedPath(fs.shareSecret, "docs/report.pdf", time.Hour)
+	if !strings.HasPrefix(link, "/docs/report.pdf?") {
+		t.Fatalf("signed link %q", link)
+	}
+	query := link[strings.Index(link, "?"):]
+	values, _ := url.ParseQuery(query[1:])
+
+	expired := url.Values{}
+	expired.Set("exp", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+	expired.Set("sig", shareSignature(fs.shareSecret, "/docs/report.pdf", time.Now().Add(-time.Minute).Unix()))
+	extended := url.Values{}
+	extended.Set("exp", strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10))
+	extended.Set("sig", values.Get("sig"))
+
+	for _, tc := range []struct {
+		name, target string
+		status       int
+	}{
+		{"valid", link, http.StatusOK},
+		{"other spelling", "/docs/./report.pdf" + query, http.StatusOK},
+		{"no signature", "/docs/report.pdf", http.StatusForbidden},
+		{"another file", "/docs/other.pdf" + query, http.StatusForbidden},
+		{"the folder", "/docs/" + query, http.StatusForbidden},
+		{"expired", "/docs/report.pdf?" + expired.Encode(), http.StatusForbidden},
+		{"moved expiry", "/docs/report.pdf?" + extended.Encode(), http.StatusForbidden},
+		{"other secret", signedPath([]byte("guess"), "docs/report.pdf", time.Hour), http.StatusForbidden},
+	} {
+		w := doRequest(fs, http.MethodGet, tc.target, nil)
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.status)
+		}
+		if tc.status != http.StatusOK && strings.Contains(w.Body.String(), "report") {
+			t.Errorf("%s: refused request leaked the file", tc.name)
+		}
+	}
+}
+
+func TestShareLinksSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"report.pdf": "report"})
+	fs := newTestServer(filepath.Join(dir, "report.pdf"))
+	fs.singleFile = true
+	fs.shareSecret = []byte("secret")
+
+	// Any request path gets the file, so the link is signed for the root
+	link := signedPath(fs.shareSecret, "/", time.Hour)
+	query := link[strings.Index(link, "?"):]
+	for _, tc := range []struct {
+		name, target string
+		status       int
+	}{
+		{"valid", link, http.StatusOK},
+		{"named", "/report.pdf" + query, http.StatusOK},
+		{"no signature", "/", http.StatusForbidden},
+		{"no signature, named", "/report.pdf", http.StatusForbidden},
+		{"other secret", signedPath([]byte("guess"), "/", time.Hour), http.StatusForbidden},
+	} {
+		w := doRequest(fs, http.MethodGet, tc.target, nil)
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.status)
+		}
+		if tc.status != http.StatusOK && strings.Contains(w.Body.String(), "report") {
+			t.Errorf("%s: refused request leaked the file", tc.name)
+		}
+	}
+}
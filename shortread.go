@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// lengthWriter counts the body bytes written against the Content-Length
+// set when the header goes out, so a body cut short can be told apart from
+// a complete one.
+type lengthWriter struct {
+	http.ResponseWriter
+	declared int64 // -1 without a Content-Length
+	written  int64
+	failed   bool // writing to the client failed, not reading the file
+	started  bool
+}
+
+func (w *lengthWriter) WriteHeader(status int) {
+	if !w.started {
+		w.started = true
+		w.declared = -1
+		if length, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil {
+			w.declared = length
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *lengthWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	w.failed = w.failed || err != nil
+	return n, err
+}
+
+// ReadFrom keeps the underlying writer's ReadFrom, and with it sendfile,
+// in use.
+func (w *lengthWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(writerOnly{w.ResponseWriter}, src)
+	}
+	w.written += n
+	// ReadFrom doesn't say which side failed; file errors are PathErrors,
+	// anything else came from the connection
+	var pathErr *os.PathError
+	w.failed = w.failed || (err != nil && !errors.As(err, &pathErr))
+	return n, err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *lengthWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// serveContent is http.ServeContent for file downloads. If the file yields
+// fewer bytes than the Content-Length derived from it, as when it shrinks
+// between Stat and the copy (common on NFS and SMB mounts, whose sizes can
+// be stale), the response is aborted rather than ended, so the client sees
+// a failed transfer instead of a silently short file. A client that went
+// away mid-download is not the file's fault; that response just ends, and
+// net/http closes the connection it can't finish.
+func (fs *FileServer) serveContent(w http.ResponseWriter, r *http.Request, name string, modTime time.Time, content io.ReadSeeker) {
+	lw := &lengthWriter{ResponseWriter: w}
+	http.ServeContent(fs.bodyWriter(lw), r, name, modTime, content)
+	if r.Method == http.MethodHead || lw.declared < 0 || lw.written >= lw.declared {
+		return
+	}
+	if lw.failed {
+		return
+	}
+	log.Printf("Warning: %s ended after %d of %d bytes, it changed while being sent; aborting the response",
+		r.URL.Path, lw.written, lw.declared)
+	panic(http.ErrAbortHandler)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shrinkingFile truncates the file at path to size on the first Read,
+// after http.ServeContent has sized the response by seeking to the end.
+type shrinkingFile struct {
+	*os.File
+	path string
+	size int64
+	done bool
+}
+
+func (f *shrinkingFile) Read(p []byte) (int, error) {
+	if !f.done {
+		f.done = true
+		if err := os.Truncate(f.path, f.size); err != nil {
+			return 0, err
+		}
+	}
+	return f.File.Read(p)
+}
+
+// captureLog sends the log package's output to a buffer for the rest of
+// the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestServeContentAbortsWhenFileShrinks(t *testing.T) {
+	logged := captureLog(t)
+	path := filepath.Join(t.TempDir(), "big.bin")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("x"), 1<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &FileServer{}
+	var logLine bytes.Buffer
+	accessLogger.SetOutput(&logLine)
+	t.Cleanup(func() { accessLogger.SetOutput(os.Stderr) })
+	handler := withAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer file.Close()
+		info, _ := file.Stat()
+		fs.serveContent(w, r, "big.bin", info.ModTime(), &shrinkingFile{File: file, path: path, size: 100000})
+	}), noopEnricher{}, false, false, nil)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ContentLength != 1<<20 {
+		t.Fatalf("Content-Length = %d, want %d", resp.ContentLength, 1<<20)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("reading the body: got %d bytes and error %v, want io.ErrUnexpectedEOF", len(body), err)
+	}
+	if len(body) > 100000 {
+		t.Errorf("got %d bytes before the abort, more than the file holds", len(body))
+	}
+
+	srv.Close() // waits for the handler, and with it the log lines
+	if !strings.Contains(logged.String(), "ended after 100000 of 1048576 bytes") {
+		t.Errorf("no short read warning logged, got %q", logged.String())
+	}
+	if !strings.Contains(logLine.String(), "status=200") {
+		t.Errorf("aborted request missing from the access log, got %q", logLine.String())
+	}
+}
+
+func TestServeContentClientGoneIsNotAborted(t *testing.T) {
+	logged := captureLog(t)
+	content := bytes.Repeat([]byte("x"), 64<<20)
+
+	fs := &FileServer{}
+	done := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panicked := true
+		defer func() {
+			if panicked {
+				recover()
+			}
+			done <- panicked
+		}()
+		fs.serveContent(w, r, "big.bin", time.Now(), bytes.NewReader(content))
+		panicked = false
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(resp.Body, make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	select {
+	case panicked := <-done:
+		if panicked {
+			t.Error("a client disconnect aborted the handler")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("handler still running after the client went away")
+	}
+	if strings.Contains(logged.String(), "Warning") {
+		t.Errorf("client disconnect logged as a short file: %q", logged.String())
+	}
+}
+
+func TestServeContentCompleteDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	fs := &FileServer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.serveContent(w, r, "file.txt", time.Now(), bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || !bytes.Equal(body, content) {
+		t.Fatalf("got %d bytes, error %v; want the whole file", len(body), err)
+	}
+}
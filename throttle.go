@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// newByteLimiter returns a token bucket allowing bytesPerSecond, with a
+// one-second burst so short responses aren't delayed needlessly.
+func newByteLimiter(bytesPerSecond int64) *rate.Limiter {
+	burst := int(bytesPerSecond)
+	if int64(burst) != bytesPerSecond || burst < 1 {
+		burst = 1 << 30
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+}
+
+// throttledWriter delays writes so the bytes written through every writer
+// sharing limiter stay within its rate. Waiting stops when ctx is done,
+// i.e. when the client goes away.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func newThrottledWriter(w http.ResponseWriter, r *http.Request, limiter *rate.Limiter) *throttledWriter {
+	return &throttledWriter{ResponseWriter: w, ctx: r.Context(), limiter: limiter}
+}
+
+func (tw *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := len(b)
+		if burst := tw.limiter.Burst(); chunk > burst {
+			chunk = burst
+		}
+		if err := tw.limiter.WaitN(tw.ctx, chunk); err != nil {
+			return written, err
+		}
+		n, err := tw.ResponseWriter.Write(b[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[chunk:]
+	}
+	return written, nil
+}
+
+// Flush lets streaming handlers flush through the throttle.
+func (tw *throttledWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (tw *throttledWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// throttle wraps w in the server-wide rate limit and the --rate-bps limit
+// of this response, as far as they are configured.
+func (fs *FileServer) throttle(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if fs.totalLimiter != nil {
+		w = newThrottledWriter(w, r, fs.totalLimiter)
+	}
+	if fs.connRate > 0 {
+		w = newThrottledWriter(w, r, newByteLimiter(fs.connRate))
+	}
+	return w
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThrottledDownloadTakesSizeOverRate(t *testing.T) {
+	const rate, size = 50000, 150000
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"big.bin": strings.Repeat("x", size)})
+
+	for name, setup := range map[string]func(*FileServer){
+		"--rate-bps":       func(fs *FileServer) { fs.connRate = rate },
+		"--max-total-rate": func(fs *FileServer) { fs.totalLimiter = newByteLimiter(rate) },
+	} {
+		fs := newTestServer(dir)
+		setup(fs)
+		start := time.Now()
+		w := doRequest(fs, http.MethodGet, "/big.bin", nil)
+		elapsed := time.Since(start)
+		if w.Code != http.StatusOK || w.Body.Len() != size {
+			t.Fatalf("%s: status %d, %d bytes", name, w.Code, w.Body.Len())
+		}
+		// The bucket starts with a one-second burst, so the rest of the
+		// file is what has to wait
+		if want := time.Duration(size-rate) * time.Second / rate; elapsed < want {
+			t.Errorf("%s: %d bytes at %d B/s took %v, want at least %v", name, size, rate, elapsed, want)
+		}
+	}
+}
+
+func TestTotalRateIsShared(t *testing.T) {
+	const rate, size = 50000, 75000
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.bin": strings.Repeat("a", size), "b.bin": strings.Repeat("b", size)})
+	fs := newTestServer(dir)
+	fs.totalLimiter = newByteLimiter(rate)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, name := range []string{"/a.bin", "/b.bin"} {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			if w := doRequest(fs, http.MethodGet, target, nil); w.Code != http.StatusOK || w.Body.Len() != size {
+				t.Errorf("%s: status %d, %d bytes", target, w.Code, w.Body.Len())
+			}
+		}(name)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	// Apart from the one-second burst, both downloads drain one bucket; a
+	// limit per connection would let them finish in a quarter of the time
+	if want := time.Duration(2*size-rate) * time.Second / rate; elapsed < want {
+		t.Errorf("two %d byte downloads at %d B/s in total took %v, want at least %v", size, rate, elapsed, want)
+	}
+}
+
+func TestTotalRateAlias(t *testing.T) {
+	defer func(old string) { *maxRate = old }(*maxRate)
+	if err := flag.Set("total-rate-bps", "2MB"); err != nil {
+		t.Fatal(err)
+	}
+	if *maxRate != "2MB" {
+		t.Errorf("--total-rate-bps set --max-total-rate to %q", *maxRate)
+	}
+	if err := flag.Set("max-total-rate", "3MB"); err != nil {
+		t.Fatal(err)
+	}
+	if got := flag.Lookup("total-rate-bps").Value.String(); got != "3MB" {
+		t.Errorf("--total-rate-bps reads %q after --max-total-rate=3MB", got)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"container/list"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// thumbCache keeps the thumbnails in the cache directory within
+// --thumb-cache-size, deleting the least recently used ones once their
+// total size goes over it. The directory persists across restarts; the
+// thumbnails already in it at startup are ranked by modification time.
+// A nil *thumbCache, as without a size limit, never evicts.
+type thumbCache struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type cachedThumb struct {
+	path string
+	size int64
+}
+
+// loadThumbCache indexes the thumbnails in dir, evicting the oldest right
+// away if they are over maxBytes already.
+func loadThumbCache(dir string, maxBytes int64) (*thumbCache, error) {
+	c := &thumbCache{maxBytes: maxBytes, order: list.New(), entries: make(map[string]*list.Element)}
+	names, err := filepath.Glob(filepath.Join(dir, "*.jpg"))
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	var paths []string
+	for _, name := range names {
+		if info, err := os.Stat(name); err == nil && info.Mode().IsRegular() {
+			infos = append(infos, info)
+			paths = append(paths, name)
+		}
+	}
+	index := make([]int, len(paths))
+	for i := range index {
+		index[i] = i
+	}
+	sort.Slice(index, func(a, b int) bool { return infos[index[a]].ModTime().Before(infos[index[b]].ModTime()) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, i := range index {
+		c.entries[paths[i]] = c.order.PushFront(&cachedThumb{path: paths[i], size: infos[i].Size()})
+		c.size += infos[i].Size()
+	}
+	c.evict()
+	return c, nil
+}
+
+// touch marks the thumbnail at path as just used.
+func (c *thumbCache) touch(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[path]; ok {
+		c.order.MoveToFront(elem)
+	}
+}
+
+// add records a thumbnail just written to path, evicting others as needed.
+func (c *thumbCache) add(path string) {
+	if c == nil {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[path]; ok {
+		c.size -= c.order.Remove(elem).(*cachedThumb).size
+	}
+	c.entries[path] = c.order.PushFront(&cachedThumb{path: path, size: info.Size()})
+	c.size += info.Size()
+	c.evict()
+}
+
+// evict deletes the least recently used thumbnails until the cache is
+// within its limit. The newest stays even if it is over the limit on its
+// own, since it is about to be served. c.mu must be held.
+func (c *thumbCache) evict() {
+	for c.size > c.maxBytes && c.order.Len() > 1 {
+		entry := c.order.Remove(c.order.Back()).(*cachedThumb)
+		delete(c.entries, entry.path)
+		c.size -= entry.size
+		if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error evicting thumbnail %s: %v", entry.path, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePNG writes a w x h image of one color to path.
+func writePNG(t *testing.T, path string, w, h int, c color.Color) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestThumbCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	root, cacheDir := t.TempDir(), t.TempDir()
+	gray := color.RGBA{128, 128, 128, 255}
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		writePNG(t, filepath.Join(root, name), 400, 300, gray)
+	}
+	fs := newTestServer(root)
+	fs.thumbnails = true
+	fs.thumbDir = cacheDir
+
+	thumb := func(name string) string {
+		t.Helper()
+		if w := doRequest(fs, http.MethodGet, "/"+name+"?thumbnail=1", nil); w.Code != http.StatusOK {
+			t.Fatalf("thumbnail of %s: status %d", name, w.Code)
+		}
+		info, _ := os.Stat(filepath.Join(root, name))
+		return fs.thumbnailPath(filepath.Join(root, name), info)
+	}
+
+	// Size the limit to two of these thumbnails
+	first := thumb("a.png")
+	info, err := os.Stat(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.thumbs, err = loadThumbCache(cacheDir, 2*info.Size()+info.Size()/2); err != nil {
+		t.Fatal(err)
+	}
+
+	b := thumb("b.png")
+	thumb("a.png") // a is now more recent than b
+	c := thumb("c.png")
+
+	for path, want := range map[string]bool{first: true, b: false, c: true} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s kept: %v, want %v", filepath.Base(path), err == nil, want)
+		}
+	}
+	if fs.thumbs.size > fs.thumbs.maxBytes {
+		t.Errorf("cache holds %d bytes, over its limit of %d", fs.thumbs.size, fs.thumbs.maxBytes)
+	}
+}
+
+func TestLoadThumbCacheEvictsOldestAtStartup(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"old.jpg", "mid.jpg", "new.jpg"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, 100), 0600); err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(time.Duration(i-3) * time.Hour)
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	if _, err := loadThumbCache(dir, 250); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"old.jpg": false, "mid.jpg": true, "new.jpg": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != want {
+			t.Errorf("%s kept: %v, want %v", name, err == nil, want)
+		}
+	}
+}
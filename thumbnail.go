@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// thumbnailSize is the longest edge of a generated thumbnail, in pixels.
+	thumbnailSize = 128
+
+	// maxThumbnailPixels keeps a single huge image from exhausting memory
+	// while it is decoded for thumbnailing.
+	maxThumbnailPixels = 50 * 1000 * 1000
+)
+
+// isThumbnailable reports whether thumbnails can be generated for filename.
+func isThumbnailable(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp":
+		return true
+	}
+	return false
+}
+
+// thumbnailPath returns where the thumbnail for a file with info is cached.
+// The key covers path, size and modification time, so a changed image gets
+// a new thumbnail.
+func (fs *FileServer) thumbnailPath(filePath string, info os.FileInfo) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d", filePath, info.Size(), info.ModTime().UnixNano())))
+	return filepath.Join(fs.thumbDir, hex.EncodeToString(sum[:16])+".jpg")
+}
+
+// serveThumbnail answers ?thumbnail requests with a small JPEG preview of
+// the image at filePath, generating and caching it on first use.
+func (fs *FileServer) serveThumbnail(w http.ResponseWriter, r *http.Request, filePath string) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		fs.serveError(w, r, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	cached := fs.thumbnailPath(filePath, info)
+	if cachedInfo, err := os.Stat(cached); err == nil {
+		setAge(w, cachedInfo.ModTime())
+		fs.thumbs.touch(cached)
+	} else if err := generateThumbnail(filePath, cached); err != nil {
+		fs.serveError(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("Cannot create thumbnail: %v", err))
+		return
+	} else {
+		fs.thumbs.add(cached)
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	fs.sendFile(w, r, cached, "image/jpeg", "inline")
+}
+
+// generateThumbnail decodes the image at src and writes a scaled-down JPEG
+// to dst. The file is written under a temporary name and renamed, so
+// concurrent requests never see a partial thumbnail.
+func generateThumbnail(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return err
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return fmt.Errorf("image is too large (%dx%d)", config.Width, config.Height)
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return err
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > thumbnailSize || height > thumbnailSize {
+		if width >= height {
+			width, height = thumbnailSize, max(1, height*thumbnailSize/width)
+		} else {
+			width, height = max(1, width*thumbnailSize/height), thumbnailSize
+		}
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(thumb, thumb.Bounds(), img, bounds, draw.Src, nil)
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".thumb-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = jpeg.Encode(tmp, thumb, &jpeg.Options{Quality: 80})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// requestTimeoutMessage is the body of the 503 sent when --request-timeout
+// runs out.
+const requestTimeoutMessage = "Service Unavailable: the request took too long"
+
+// timed runs handle under --request-timeout. It is meant for handlers that
+// compute a bounded answer, such as listings and checksums:
+// http.TimeoutHandler buffers the whole response, so file downloads and
+// other streams must not go through it. Handlers should watch
+// r.Context() to stop working once the 503 has been sent.
+func (fs *FileServer) timed(w http.ResponseWriter, r *http.Request, handle http.HandlerFunc) {
+	if fs.requestTimeout <= 0 {
+		handle(w, r)
+		return
+	}
+	http.TimeoutHandler(handle, fs.requestTimeout, requestTimeoutMessage).ServeHTTP(w, r)
+}
+
+// limitStream applies --request-timeout to a streamed response such as a
+// ZIP archive, which has started with 200 long before it could time out.
+// Writes fail after the deadline, and the returned request's context ends
+// with it so the handler can stop.
+func (fs *FileServer) limitStream(w http.ResponseWriter, r *http.Request) (*http.Request, context.CancelFunc) {
+	if fs.requestTimeout <= 0 {
+		return r, func() {}
+	}
+	deadline := time.Now().Add(fs.requestTimeout)
+	http.NewResponseController(w).SetWriteDeadline(deadline)
+	ctx, cancel := context.WithDeadline(r.Context(), deadline)
+	return r.WithContext(ctx), cancel
+}
+
+// contextReader stops reading once ctx is done, so long copies can be
+// abandoned.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// checksumTrailer is the trailer --checksum-trailer sends the hex SHA-256
+// of a download in.
+const checksumTrailer = "X-Content-SHA256"
+
+// acceptsTrailers reports whether the client said it takes trailers with
+// TE: trailers, as RFC 9110 requires before a sender relies on them.
+func acceptsTrailers(r *http.Request) bool {
+	for _, value := range r.Header.Values("TE") {
+		for _, token := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(token, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "trailers") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wantsChecksumTrailer reports whether the response to r should end with
+// the checksum trailer. Only whole, unencoded bodies get one, since the
+// digest covers the file as stored.
+func (fs *FileServer) wantsChecksumTrailer(w http.ResponseWriter, r *http.Request) bool {
+	return fs.sumTrailer && r.Method == http.MethodGet && acceptsTrailers(r) &&
+		r.Header.Get("Range") == "" && w.Header().Get("Content-Encoding") == ""
+}
+
+// hashingContent hashes what http.ServeContent reads from the content. A
+// Seek starts the digest over, so only the bytes of the final pass count.
+type hashingContent struct {
+	io.ReadSeeker
+	hash hash.Hash
+	read int64
+}
+
+func (c *hashingContent) Read(p []byte) (int, error) {
+	n, err := io.TeeReader(c.ReadSeeker, c.hash).Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+func (c *hashingContent) Seek(offset int64, whence int) (int64, error) {
+	c.hash.Reset()
+	c.read = 0
+	return c.ReadSeeker.Seek(offset, whence)
+}
+
+// trailerWriter drops the Content-Length http.ServeContent sets, since
+// trailers need a chunked HTTP/1.1 body, and remembers the status.
+type trailerWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *trailerWriter) WriteHeader(status int) {
+	w.status = status
+	if status == http.StatusOK {
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *trailerWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// serveWithChecksumTrailer serves content like sendFile, followed by its
+// SHA-256 in the checksum trailer, computed as the body goes out.
+func (fs *FileServer) serveWithChecksumTrailer(w http.ResponseWriter, r *http.Request, name string, info os.FileInfo, content io.ReadSeeker) {
+	w.Header().Set("Trailer", checksumTrailer)
+	hashed := &hashingContent{ReadSeeker: content, hash: sha256.New()}
+	tw := &trailerWriter{ResponseWriter: w}
+	fs.serveContent(tw, r, name, info.ModTime(), hashed)
+	if tw.status == http.StatusOK && hashed.read == info.Size() {
+		w.Header().Set(checksumTrailer, hex.EncodeToString(hashed.hash.Sum(nil)))
+	}
+}
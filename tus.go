@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// tusEndpoint is where --tus answers the tus resumable upload protocol
+// (https://tus.io/protocols/resumable-upload): POST creates an upload,
+// HEAD on tusEndpoint/<id> reports its offset and PATCH appends to it.
+const (
+	tusEndpoint = "/.tus"
+	tusVersion  = "1.0.0"
+)
+
+// tusUpload is the state of an upload in progress, kept as <id>.json next
+// to the partial data in the tus directory. The offset is the size of the
+// data file, so it survives a restart without extra bookkeeping.
+type tusUpload struct {
+	Length int64  `json:"length"`
+	Name   string `json:"name"`
+	Dir    string `json:"dir"`
+}
+
+// serveTus handles requests under tusEndpoint.
+func (fs *FileServer) serveTus(w http.ResponseWriter, r *http.Request, urlPath string) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation")
+		if fs.maxUploadSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(fs.maxUploadSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		fs.serveError(w, r, http.StatusPreconditionFailed, "Precondition Failed: unsupported Tus-Resumable version")
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(urlPath, tusEndpoint), "/")
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		fs.createTusUpload(w, r)
+	case id == "":
+		w.Header().Set("Allow", "OPTIONS, POST")
+		fs.serveError(w, r, http.StatusMethodNotAllowed, "Method Not Allowed")
+	case !validTusID(id):
+		fs.serveError(w, r, http.StatusNotFound, "Not Found")
+	case r.Method == http.MethodHead:
+		fs.headTusUpload(w, r, id)
+	case r.Method == http.MethodPatch:
+		fs.patchTusUpload(w, r, id)
+	default:
+		w.Header().Set("Allow", "OPTIONS, HEAD, PATCH")
+		fs.serveError(w, r, http.StatusMethodNotAllowed, "Method Not Allowed")
+	}
+}
+
+// createTusUpload starts an upload of Upload-Length bytes. The file name
+// comes from the "filename" key of Upload-Metadata; like multipart uploads
+// it lands in --upload-dir, or the serve root without one.
+func (fs *FileServer) createTusUpload(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		fs.serveError(w, r, http.StatusBadRequest, "Bad Request: Upload-Length is required")
+		return
+	}
+	if fs.maxUploadSize > 0 && length > fs.maxUploadSize {
+		fs.serveError(w, r, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+		return
+	}
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		fs.serveError(w, r, http.StatusBadRequest, fmt.Sprintf("Bad Request: Upload-Metadata: %v", err))
+		return
+	}
+	name, ok := sanitizeFilename(metadata["filename"])
+	if !ok {
+		fs.serveError(w, r, http.StatusBadRequest, "Bad Request: Upload-Metadata needs a valid filename")
+		return
+	}
+
+	upload := tusUpload{Length: length, Name: name, Dir: fs.servePath}
+	if fs.uploadDir != "" {
+		upload.Dir = fs.uploadDir
+	}
+	// Refuse up front what the final rename would be refused
+	if !fs.writeAllowed(upload.Dir) {
+		fs.writeForbidden(w, r)
+		return
+	}
+	if err := fs.checkAccess(r, filepath.Join(upload.Dir, name)); err != nil {
+		fs.writeRequestError(w, r, err)
+		return
+	}
+
+	id := newRequestID()
+	if err := fs.saveTusUpload(id, upload); err != nil {
+		log.Printf("Error creating tus upload: %v", err)
+		fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error creating upload: %v", err))
+		return
+	}
+	location := fs.baseURL + tusEndpoint + "/" + id
+	if length == 0 {
+		if _, err := fs.finishTusUpload(r, id, upload); err != nil {
+			fs.writeRequestError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// headTusUpload reports how much of an upload the server has.
+func (fs *FileServer) headTusUpload(w http.ResponseWriter, r *http.Request, id string) {
+	upload, offset, err := fs.loadTusUpload(id)
+	if err != nil {
+		fs.serveError(w, r, http.StatusNotFound, "Not Found")
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// patchTusUpload appends the body at Upload-Offset, which must be where the
+// upload currently ends. Whatever arrives before a connection drops is
+// kept, so the client can resume from there. The last byte moves the file
+// into place.
+func (fs *FileServer) patchTusUpload(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		fs.serveError(w, r, http.StatusUnsupportedMediaType, "Unsupported Media Type: expected application/offset+octet-stream")
+		return
+	}
+	requested, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || requested < 0 {
+		fs.serveError(w, r, http.StatusBadRequest, "Bad Request: Upload-Offset is required")
+		return
+	}
+
+	unlock := fs.lockUploadPath(fs.tusDataPath(id))
+	defer unlock()
+
+	upload, offset, err := fs.loadTusUpload(id)
+	if err != nil {
+		fs.serveError(w, r, http.StatusNotFound, "Not Found")
+		return
+	}
+	if requested != offset {
+		fs.serveError(w, r, http.StatusConflict, fmt.Sprintf("Conflict: upload is at offset %d", offset))
+		return
+	}
+
+	file, err := os.OpenFile(fs.tusDataPath(id), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error writing file: %v", err))
+		return
+	}
+	written, copyErr := fs.copy(file, io.LimitReader(r.Body, upload.Length-offset))
+	if err := file.Close(); copyErr == nil {
+		copyErr = err
+	}
+	offset += written
+	if copyErr != nil {
+		log.Printf("tus upload %s interrupted at offset %d: %v", id, offset, copyErr)
+	}
+
+	if offset == upload.Length {
+		if _, err := fs.finishTusUpload(r, id, upload); err != nil {
+			fs.writeRequestError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishTusUpload moves a complete upload to its directory, under a
+// numbered variant of its name if that is taken, and returns the path.
+func (fs *FileServer) finishTusUpload(r *http.Request, id string, upload tusUpload) (string, error) {
+	filePath, err := fs.uniqueUploadPath(r, upload.Dir, upload.Name)
+	if err != nil {
+		return "", err
+	}
+	if err := fs.mkdirAll(upload.Dir); err != nil {
+		return "", &requestError{http.StatusInternalServerError, fmt.Sprintf("Error creating directory: %v", err)}
+	}
+	if err := moveFile(fs.tusDataPath(id), filePath, fs.fileMode); err != nil {
+		log.Printf("Error finishing tus upload %s: %v", id, err)
+		return "", &requestError{http.StatusInternalServerError, fmt.Sprintf("Error writing file: %v", err)}
+	}
+	os.Remove(fs.tusInfoPath(id))
+	return filePath, nil
+}
+
+func (fs *FileServer) tusDataPath(id string) string { return filepath.Join(fs.tusDir, id) }
+func (fs *FileServer) tusInfoPath(id string) string { return filepath.Join(fs.tusDir, id+".json") }
+
+// saveTusUpload records a new upload and creates its empty data file.
+func (fs *FileServer) saveTusUpload(id string, upload tusUpload) error {
+	if err := os.MkdirAll(fs.tusDir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(fs.tusInfoPath(id), data, 0600); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(fs.tusDataPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		os.Remove(fs.tusInfoPath(id))
+		return err
+	}
+	return file.Close()
+}
+
+// loadTusUpload returns an upload and its current offset.
+func (fs *FileServer) loadTusUpload(id string) (tusUpload, int64, error) {
+	var upload tusUpload
+	data, err := os.ReadFile(fs.tusInfoPath(id))
+	if err != nil {
+		return upload, 0, err
+	}
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return upload, 0, err
+	}
+	info, err := os.Stat(fs.tusDataPath(id))
+	if err != nil {
+		return upload, 0, err
+	}
+	return upload, info.Size(), nil
+}
+
+// validTusID accepts the hex IDs createTusUpload hands out, so an ID can
+// never name a path outside the tus directory.
+func validTusID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma-separated
+// pairs of a key and a base64 value, the value being optional.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %q", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// moveFile renames src to dst, falling back to copying through a temporary
+// file next to dst when they are on different filesystems, and gives dst
+// the permissions mode.
+func moveFile(src, dst string, mode os.FileMode) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return os.Chmod(dst, mode)
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, in)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// parseByteSize parses a size such as "512", "10KB" or "1.5G" into bytes.
+// Suffixes are binary multiples and case-insensitive.
+func parseByteSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(value, unit.suffix) {
+			multiplier = unit.size
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// handleUpload stores the request body at filePath for PUT requests in
+// writable mode. Bodies larger than maxUploadSize are rejected with 413.
+// Every check that can refuse the upload runs before the body is read, so
+// clients sending Expect: 100-continue don't upload it in vain. The body is
+// written to a temporary file next to filePath and renamed into place, so
+// readers and concurrent uploads never see a partial or interleaved file;
+// uploads to the same path are also serialized. If-Match and
+// If-Unmodified-Since make the upload conditional on the file being
+// unchanged, see preconditionFailed. --upload-dir doesn't apply: a PUT
+// names the exact file, which conditional edits and WebDAV clients rely on.
+func (fs *FileServer) handleUpload(w http.ResponseWriter, r *http.Request, filePath string) {
+	if !fs.writeAllowed(filePath) {
+		fs.writeForbidden(w, r)
+		return
+	}
+	info, err := os.Stat(filePath)
+	if err == nil && info.IsDir() {
+		fs.serveError(w, r, http.StatusConflict, "Conflict: Cannot overwrite a directory")
+		return
+	}
+	if err != nil {
+		info = nil
+	}
+	if preconditionFailed(r, info) {
+		fs.serveError(w, r, http.StatusPreconditionFailed, "Precondition Failed: the file has changed")
+		return
+	}
+
+	body := r.Body
+	if fs.maxUploadSize > 0 {
+		if r.ContentLength > fs.maxUploadSize {
+			fs.serveError(w, r, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+			return
+		}
+		body = http.MaxBytesReader(w, r.Body, fs.maxUploadSize)
+	}
+
+	dir := filepath.Dir(filePath)
+	if err := fs.mkdirAll(dir); err != nil {
+		fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error creating directory: %v", err))
+		return
+	}
+
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error writing file: %v", err))
+		return
+	}
+	// Don't leave a temporary file behind; after the rename this is a no-op
+	defer os.Remove(tmp.Name())
+
+	_, err = fs.copy(tmp, body)
+	if err == nil {
+		err = tmp.Chmod(fs.fileMode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			fs.serveError(w, r, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+			return
+		}
+		log.Printf("Error writing upload %s: %v", filePath, err)
+		fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error writing file: %v", err))
+		return
+	}
+
+	// The body is complete; only the swap is serialized, so a slow client
+	// doesn't hold up others writing the same path
+	unlock := fs.lockUploadPath(filePath)
+	defer unlock()
+
+	info, statErr := os.Stat(filePath)
+	created := os.IsNotExist(statErr)
+	if created {
+		info = nil
+	}
+	// Checked again now that no other upload can get in between, in case
+	// one replaced the file while this body was arriving
+	if preconditionFailed(r, info) {
+		fs.serveError(w, r, http.StatusPreconditionFailed, "Precondition Failed: the file has changed")
+		return
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		log.Printf("Error writing upload %s: %v", filePath, err)
+		fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error writing file: %v", err))
+		return
+	}
+
+	// The new validator, for the client's next conditional PUT
+	if written, err := os.Stat(filePath); err == nil {
+		w.Header().Set("ETag", fileETag(written))
+	}
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// preconditionFailed reports whether r's If-Match or If-Unmodified-Since
+// rules out replacing the file whose current FileInfo is info, nil when
+// there is none. They are compared with the ETag and Last-Modified GET
+// sends, so a client can PUT back a file it read only if nobody changed it
+// in the meantime. If-Match uses the strong comparison and "*" only needs
+// the file to exist; If-Unmodified-Since is ignored alongside If-Match, as
+// RFC 9110 has it.
+func preconditionFailed(r *http.Request, info os.FileInfo) bool {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if info == nil {
+			return true
+		}
+		current := fileETag(info)
+		for _, tag := range strings.Split(ifMatch, ",") {
+			if tag = strings.TrimSpace(tag); tag == "*" || tag == current {
+				return false
+			}
+		}
+		return true
+	}
+	if since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && info != nil {
+		// Last-Modified has whole seconds
+		return info.ModTime().Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// parseFileMode parses an octal permission value such as 0644 or 2775 for
+// --upload-mode and --upload-dir-mode. The setuid, setgid and sticky bits
+// are accepted too.
+func parseFileMode(s string) (os.FileMode, error) {
+	bits, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || bits > 07777 {
+		return 0, fmt.Errorf("invalid mode %q (want octal permissions such as 0644)", s)
+	}
+	mode := os.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
+
+// mkdirAll is os.MkdirAll for uploads: the directories it creates get
+// exactly --upload-dir-mode rather than that mode filtered through the
+// umask.
+func (fs *FileServer) mkdirAll(dir string) error {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := fs.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, fs.dirMode); err != nil {
+		if os.IsExist(err) {
+			return nil // created concurrently
+		}
+		return err
+	}
+	return os.Chmod(dir, fs.dirMode)
+}
+
+// lockUploadPath takes the write lock for filePath and returns the function
+// that releases it. Locks are created on first use and kept, one per path
+// ever written.
+func (fs *FileServer) lockUploadPath(filePath string) (unlock func()) {
+	value, _ := fs.uploadLocks.LoadOrStore(filePath, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// uploadResult is the JSON answer to a multipart upload.
+type uploadResult struct {
+	Files []string `json:"files"`
+}
+
+// handleMultipartUpload stores the file parts of a multipart/form-data POST
+// in dirPath, or in --upload-dir when set, whatever the request path. Part
+// filenames come from the client, so only their base name is used and a
+// name that is taken gets a numbered variant instead of replacing a file.
+// It answers 201 with the URL paths of the saved files. As with PUT, the
+// target and size are checked before the body is read.
+func (fs *FileServer) handleMultipartUpload(w http.ResponseWriter, r *http.Request, dirPath string) {
+	if fs.uploadDir != "" {
+		dirPath = fs.uploadDir
+		if err := fs.mkdirAll(dirPath); err != nil {
+			fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error creating directory: %v", err))
+			return
+		}
+	}
+	if !fs.writeAllowed(dirPath) {
+		fs.writeForbidden(w, r)
+		return
+	}
+	if info, err := os.Stat(dirPath); err != nil || !info.IsDir() {
+		fs.serveError(w, r, http.StatusConflict, "Conflict: Uploads must be posted to a directory")
+		return
+	}
+
+	if fs.maxUploadSize > 0 {
+		if r.ContentLength > fs.maxUploadSize {
+			fs.serveError(w, r, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, fs.maxUploadSize)
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		fs.serveError(w, r, http.StatusBadRequest, "Bad Request: expected multipart/form-data")
+		return
+	}
+
+	result := uploadResult{Files: []string{}}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fs.writeUploadError(w, r, err)
+			return
+		}
+		if part.FileName() == "" {
+			continue // an ordinary form field
+		}
+
+		name, ok := sanitizeFilename(part.FileName())
+		if !ok {
+			fs.serveError(w, r, http.StatusBadRequest, fmt.Sprintf("Bad Request: invalid filename %q", part.FileName()))
+			return
+		}
+		filePath, err := fs.uniqueUploadPath(r, dirPath, name)
+		if err != nil {
+			fs.writeRequestError(w, r, err)
+			return
+		}
+
+		file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fs.fileMode)
+		if err != nil {
+			fs.serveError(w, r, http.StatusInternalServerError, fmt.Sprintf("Error writing file: %v", err))
+			return
+		}
+		_, err = fs.copy(file, part)
+		if err == nil {
+			err = file.Chmod(fs.fileMode)
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(filePath)
+			fs.writeUploadError(w, r, err)
+			return
+		}
+		result.Files = append(result.Files, fs.baseURL+fs.rootRelative(filePath))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
+
+// sanitizeFilename reduces a client-supplied filename to its base name,
+// treating backslashes as separators too. It reports false for names that
+// are empty or still special after that.
+func sanitizeFilename(name string) (string, bool) {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == ".." || name == "/" || name == "" {
+		return "", false
+	}
+	for _, c := range name {
+		if c < ' ' || c == 0x7f {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// uniqueUploadPath returns the path for name in dirPath, switching to
+// "name (1).ext", "name (2).ext", ... while the name is taken. The result is
+// checked against the serve root and the access rules like a request path.
+func (fs *FileServer) uniqueUploadPath(r *http.Request, dirPath, name string) (string, error) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; ; i++ {
+		filePath := filepath.Join(dirPath, candidate)
+		if filepath.Dir(filePath) != filepath.Clean(dirPath) {
+			return "", &requestError{http.StatusBadRequest, "Bad Request: invalid filename"}
+		}
+		if err := fs.checkAccess(r, filePath); err != nil {
+			return "", err
+		}
+		if _, err := os.Lstat(filePath); os.IsNotExist(err) {
+			return filePath, nil
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", stem, i, ext)
+	}
+}
+
+// writeUploadError answers a failed multipart upload, telling an oversized
+// body apart from other read errors.
+func (fs *FileServer) writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		fs.serveError(w, r, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+		return
+	}
+	log.Printf("Error reading upload: %v", err)
+	fs.serveError(w, r, http.StatusBadRequest, fmt.Sprintf("Bad Request: %v", err))
+}
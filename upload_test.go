@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentPutsLeaveOneCompleteVersion(t *testing.T) {
+	dir := t.TempDir()
+	fs := newTestServer(dir)
+	fs.writable = true
+
+	const writers = 8
+	bodies := make([][]byte, writers)
+	for i := range bodies {
+		bodies[i] = bytes.Repeat([]byte{'a' + byte(i)}, 512<<10)
+	}
+	var wg sync.WaitGroup
+	for _, body := range bodies {
+		wg.Add(1)
+		go func(body []byte) {
+			defer wg.Done()
+			if w := doRequest(fs, http.MethodPut, "/shared.bin", bytes.NewReader(body)); w.Code != http.StatusCreated && w.Code != http.StatusNoContent {
+				t.Errorf("PUT: status %d", w.Code)
+			}
+		}(body)
+	}
+	wg.Wait()
+
+	got, err := os.ReadFile(filepath.Join(dir, "shared.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	complete := false
+	for _, body := range bodies {
+		complete = complete || bytes.Equal(got, body)
+	}
+	if !complete {
+		t.Errorf("final file (%d bytes, starting %q) is not one of the uploads", len(got), got[:min(len(got), 8)])
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".upload-*")); len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestStalePutGets412(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"notes.txt": "v1"})
+	fs := newTestServer(dir)
+	fs.writable = true
+
+	// Two editors read version 1
+	read := doRequest(fs, http.MethodGet, "/notes.txt", nil)
+	etag, lastModified := read.Header().Get("ETag"), read.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("GET sent ETag %q, Last-Modified %q", etag, lastModified)
+	}
+
+	// The first saves, which changes the ETag
+	saved := doRequest(fs, http.MethodPut, "/notes.txt", bytes.NewBufferString("v2 from the first editor"), "If-Match", etag)
+	if saved.Code != http.StatusNoContent || saved.Header().Get("ETag") == etag {
+		t.Fatalf("first PUT: status %d, ETag %q", saved.Code, saved.Header().Get("ETag"))
+	}
+	// Make sure the second-resolution Last-Modified moves on as well
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(dir, "notes.txt"), later, later)
+
+	for name, header := range map[string][]string{
+		"If-Match":            {"If-Match", etag},
+		"If-Unmodified-Since": {"If-Unmodified-Since", lastModified},
+	} {
+		w := doRequest(fs, http.MethodPut, "/notes.txt", bytes.NewBufferString("v2 from the second editor"), header...)
+		if w.Code != http.StatusPreconditionFailed {
+			t.Errorf("stale %s: status %d, want 412", name, w.Code)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "notes.txt")); string(got) != "v2 from the first editor" {
+		t.Errorf("file is %q after the stale updates", got)
+	}
+
+	// A client that never saw the file can't create it with If-Match
+	if w := doRequest(fs, http.MethodPut, "/new.txt", bytes.NewBufferString("x"), "If-Match", "*"); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-Match: * on a missing file: status %d, want 412", w.Code)
+	}
+}
+
+// multipartBody returns a multipart/form-data body with one file part per
+// filename, and its Content-Type.
+func multipartBody(t *testing.T, files map[string]string) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+	}
+	mw.Close()
+	return &body, mw.FormDataContentType()
+}
+
+func TestMultipartUploadSanitizesNames(t *testing.T) {
+	captureLog(t)
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "root")
+	writeFiles(t, parent, map[string]string{"root/incoming/taken.txt": "old"})
+	fs := newTestServer(dir)
+	fs.writable = true
+	fs.uploadDir = filepath.Join(dir, "incoming")
+
+	body, contentType := multipartBody(t, map[string]string{
+		"../../evil.txt":         "evil",
+		`..\..\windows.txt`:      "win",
+		"taken.txt":              "new",
+		"/etc/cron.d/hourly.txt": "cron",
+	})
+	w := doRequest(fs, http.MethodPost, "/", body, "Content-Type", contentType)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var result uploadResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(result.Files)
+	want := []string{"/incoming/evil.txt", "/incoming/hourly.txt", "/incoming/taken (1).txt", "/incoming/windows.txt"}
+	if strings.Join(result.Files, " ") != strings.Join(want, " ") {
+		t.Errorf("saved %v, want %v", result.Files, want)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "evil.txt")); err == nil {
+		t.Error("a part name escaped the upload folder")
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "incoming", "taken.txt")); string(got) != "old" {
+		t.Errorf("an existing file was replaced: %q", got)
+	}
+
+	for _, name := range []string{"..", "bad\x01name.txt"} {
+		body, contentType := multipartBody(t, map[string]string{name: "x"})
+		if w := doRequest(fs, http.MethodPost, "/", body, "Content-Type", contentType); w.Code != http.StatusBadRequest {
+			t.Errorf("filename %q: status %d, want 400", name, w.Code)
+		}
+	}
+}
+
+func TestPutIgnoresUploadDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"incoming/.keep": ""})
+	fs := newTestServer(dir)
+	fs.writable = true
+	fs.uploadDir = filepath.Join(dir, "incoming")
+
+	if w := doRequest(fs, http.MethodPut, "/docs/notes.txt", strings.NewReader("notes")); w.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "docs", "notes.txt")); err != nil || string(got) != "notes" {
+		t.Errorf("file at the requested path holds %q, error %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "incoming", "notes.txt")); err == nil {
+		t.Error("PUT was routed into --upload-dir")
+	}
+}
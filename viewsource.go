@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// sourceExtensions are the source and config files --view-source shows as
+// text in the browser. getMimeType knows none of them, so without the flag
+// they download as application/octet-stream.
+var sourceExtensions = map[string]bool{
+	".go": true, ".py": true, ".rb": true, ".pl": true, ".php": true, ".lua": true,
+	".sh": true, ".bash": true, ".zsh": true, ".fish": true, ".ps1": true, ".bat": true,
+	".c": true, ".h": true, ".cc": true, ".cpp": true, ".hpp": true, ".cs": true,
+	".java": true, ".kt": true, ".scala": true, ".swift": true, ".rs": true, ".zig": true,
+	".jsx": true, ".tsx": true, ".mjs": true, ".vue": true,
+	".sql": true, ".r": true, ".ex": true, ".exs": true, ".erl": true, ".hs": true,
+	".yaml": true, ".yml": true, ".toml": true, ".ini": true, ".conf": true, ".cfg": true,
+	".env": true, ".properties": true, ".log": true, ".diff": true, ".patch": true,
+	".gradle": true, ".cmake": true, ".proto": true, ".graphql": true,
+}
+
+// sourceNames are extensionless files that are source all the same.
+var sourceNames = map[string]bool{
+	"makefile": true, "dockerfile": true, "gemfile": true, "rakefile": true,
+	"procfile": true, "vagrantfile": true, "jenkinsfile": true,
+}
+
+// isSourceFile reports whether --view-source applies to filename. A --mime
+// or --mime-file type for the extension takes precedence.
+func isSourceFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if _, ok := mimeOverrides[ext]; ok {
+		return false
+	}
+	return sourceExtensions[ext] || (ext == "" && sourceNames[strings.ToLower(filename)])
+}
+
+// wantsSourceView reports whether a request for the file at filePath should
+// be shown as text in the browser rather than downloaded. ?download=1 gets
+// the attachment as before.
+func (fs *FileServer) wantsSourceView(r *http.Request, filePath string) bool {
+	return fs.viewSource && isSourceFile(filepath.Base(filePath)) && !r.URL.Query().Has("download")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// invalidate drops everything cached about path, which has changed or is
+// gone. The directory holding it has a new entry count and listing too.
+func (fs *FileServer) invalidate(path string) {
+	fs.fileCache.forget(path)
+	fs.checksums.forget(path)
+	fs.markdownPages.forget(path)
+	fs.readmePages.forget(path)
+	fs.childCountCache.forget(path)
+	fs.childCountCache.forget(filepath.Dir(path))
+	fs.listingPages.forget(path)
+	fs.listingPages.forget(filepath.Dir(path))
+}
+
+// watchTree implements --watch: it watches every directory below the roots and
+// invalidates cached data about files as they change, instead of waiting
+// for a request to notice. The caches still check a Stat on every lookup,
+// so anything the watcher can't see (a filesystem without notifications,
+// directories past the inotify watch limit) is handled as it was without
+// the flag. Thumbnails are files named after the source's size and ModTime
+// and need no invalidation.
+func watchTree(fs *FileServer) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	w := &treeWatcher{watcher: watcher}
+	for _, root := range fs.roots() {
+		w.addTree(root)
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				path := filepath.Clean(event.Name)
+				fs.invalidate(path)
+				switch {
+				case event.Has(fsnotify.Create):
+					// New directories, and what was created in them
+					// before the watch was in place
+					w.addTree(path)
+				case event.Has(fsnotify.Rename):
+					// A renamed directory would keep reporting under
+					// its old name; the Create at its new one adds it
+					// again
+					watcher.Remove(path)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Warning: --watch: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+type treeWatcher struct {
+	watcher *fsnotify.Watcher
+	full    bool // the watch limit was hit, so nothing more is added
+}
+
+// addTree watches root, if it is a directory, and every directory below
+// it. Directories that can't be read are skipped.
+func (w *treeWatcher) addTree(root string) {
+	if w.full {
+		return
+	}
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if err := w.watcher.Add(path); err != nil {
+			if errors.Is(err, syscall.ENOSPC) {
+				w.full = true
+				log.Printf("Warning: --watch: inotify watch limit reached at %s; directories from here on rely on Stat checks. Raise fs.inotify.max_user_watches to watch them all", path)
+				return filepath.SkipAll
+			}
+			log.Printf("Warning: --watch: %s: %v", path, err)
+		}
+		return nil
+	})
+}
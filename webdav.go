@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// webdavReadMethods are answered by the WebDAV handler with --webdav. GET
+// and HEAD are not among them: files and listings are served as usual, and
+// OPTIONS is answered by serveOptions, which knows what is enabled.
+var webdavReadMethods = map[string]bool{
+	"PROPFIND": true,
+}
+
+// webdavWriteMethods additionally need --writable. PUT is handled by the
+// regular upload code.
+var webdavWriteMethods = map[string]bool{
+	"PROPPATCH":       true,
+	"MKCOL":           true,
+	"COPY":            true,
+	"MOVE":            true,
+	"LOCK":            true,
+	"UNLOCK":          true,
+	http.MethodDelete: true,
+}
+
+// newWebDAVHandler serves the WebDAV methods on the same root as fs,
+// mounted at its --base-url and with --hide matches left out.
+func newWebDAVHandler(fs *FileServer) *webdav.Handler {
+	return &webdav.Handler{
+		Prefix:     fs.baseURL,
+		FileSystem: hidingFileSystem{FileSystem: modeFileSystem{webdav.Dir(fs.servePath), fs}, server: fs},
+		LockSystem: webdav.NewMemLS(),
+	}
+}
+
+// modeFileSystem creates MKCOL collections with --upload-dir-mode. Files
+// only come from PUT, which the upload code handles, and from COPY, which
+// keeps the source's mode.
+type modeFileSystem struct {
+	webdav.Dir
+	server *FileServer
+}
+
+func (m modeFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := m.Dir.Mkdir(ctx, name, m.server.dirMode); err != nil {
+		return err
+	}
+	// Exactly the configured mode, not filtered through the umask
+	return os.Chmod(filepath.Join(string(m.Dir), filepath.FromSlash(path.Clean("/"+name))), m.server.dirMode)
+}
+
+// destinationURLPath returns the path of the Destination header of a COPY
+// or MOVE request, below --base-url. The header may be a full URL or just
+// a path.
+func (fs *FileServer) destinationURLPath(r *http.Request) (string, error) {
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || !strings.HasPrefix(u.Path, fs.baseURL+"/") {
+		return "", &requestError{http.StatusBadRequest, "Bad Request: invalid Destination"}
+	}
+	return strings.TrimPrefix(u.Path, fs.baseURL), nil
+}
+
+func isWebDAVMethod(method string) bool {
+	return webdavReadMethods[method] || webdavWriteMethods[method]
+}
+
+// serveWebDAV hands a WebDAV request for urlPath (below --base-url) to the
+// WebDAV handler, after the same traversal and access checks as any other
+// request. COPY and MOVE destinations are checked as well, and every path a
+// write changes has to be allowed by --write-prefix; a COPY source is only
+// read.
+func (fs *FileServer) serveWebDAV(w http.ResponseWriter, r *http.Request, urlPath string) {
+	if webdavWriteMethods[r.Method] && !fs.writable {
+		w.Header().Set("Allow", fs.allowedMethods(false))
+		fs.serveError(w, r, http.StatusMethodNotAllowed, "Method Not Allowed: server is read-only")
+		return
+	}
+
+	paths := []string{urlPath}
+	if r.Header.Get("Destination") != "" {
+		destination, err := fs.destinationURLPath(r)
+		if err != nil {
+			fs.writeRequestError(w, r, err)
+			return
+		}
+		paths = append(paths, destination)
+	}
+	for i, p := range paths {
+		absPath, err := fs.resolvePath(strings.TrimPrefix(p, "/"))
+		if err == nil {
+			err = fs.checkAccess(r, absPath)
+		}
+		if err != nil {
+			fs.writeRequestError(w, r, err)
+			return
+		}
+		changed := webdavWriteMethods[r.Method] && !(r.Method == "COPY" && i == 0)
+		if changed && !fs.writeAllowed(absPath) {
+			fs.writeForbidden(w, r)
+			return
+		}
+	}
+
+	// The file system gets the request for the per-entry access checks
+	// of collection listings
+	fs.webdav.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), webdavRequestKey{}, r)))
+}
+
+// webdavRequestKey is the context key of the request a WebDAV file system
+// call is made for.
+type webdavRequestKey struct{}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// propfind returns the body of a Depth: 1 PROPFIND for target.
+func propfind(t *testing.T, fs *FileServer, target string, header ...string) string {
+	t.Helper()
+	w := doRequest(fs, "PROPFIND", target, nil, append([]string{"Depth", "1"}, header...)...)
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND %s: status %d, want 207", target, w.Code)
+	}
+	return w.Body.String()
+}
+
+func TestPropfindLeavesOutInaccessibleEntries(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"public.txt":        "a",
+		"secret/plans.txt":  "b",
+		"notes.bak":         "c",
+		"deep/deeper/x.txt": "d",
+	})
+	fs := newTestServer(dir)
+	fs.hidePatterns = []string{"*.bak"}
+	fs.acl.Store(&accessList{
+		users: map[string]string{"alice": "pw"},
+		rules: []aclRule{{pattern: "/secret", users: []string{"alice"}}},
+	})
+	fs.webdav = newWebDAVHandler(fs)
+
+	body := propfind(t, fs, "/")
+	for name, want := range map[string]bool{"public.txt": true, "deep/": true, "secret/": false, "notes.bak": false} {
+		if got := strings.Contains(body, "<D:href>/"+name+"</D:href>"); got != want {
+			t.Errorf("anonymous PROPFIND lists %s: %v, want %v", name, got, want)
+		}
+	}
+	if body := propfind(t, fs, "/", "Authorization", "Basic YWxpY2U6cHc="); !strings.Contains(body, "<D:href>/secret/</D:href>") {
+		t.Error("PROPFIND as alice leaves out /secret/")
+	}
+
+	fs.maxDepth = 1
+	if body := propfind(t, fs, "/deep/"); strings.Contains(body, "deeper") {
+		t.Error("PROPFIND lists a directory past --max-depth")
+	}
+
+	fs.shareSecret = []byte("key")
+	link := signedPath(fs.shareSecret, "/", time.Hour)
+	if body := propfind(t, fs, link); strings.Contains(body, "public.txt") {
+		t.Error("a share link for / reveals children it doesn't cover")
+	}
+}
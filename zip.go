@@ -0,0 +1,135 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// serveZip streams the directory at dirPath as a ZIP archive. Only the
+// immediate files are included unless recursive is set. Entries that can't
+// be read are skipped rather than failing the whole archive, since the
+// response has already started by the time they are reached, and so are
+// entries the access rules would refuse to the client. The archive
+// goes out chunked and is flushed after every entry; once the client is
+// gone, the walk stops at the next entry.
+func (fs *FileServer) serveZip(w http.ResponseWriter, r *http.Request, dirPath string, recursive bool) {
+	name := filepath.Base(dirPath)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", name+".zip"))
+	// Neither the size nor byte offsets are known before the archive is
+	// built, so it can't be resumed and has no Content-Length
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Del("Content-Length")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	r, cancel := fs.limitStream(w, r)
+	defer cancel()
+
+	// Send the headers now, so the download starts before the first entry
+	rc := http.NewResponseController(w)
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	zw := zip.NewWriter(fs.throttle(w, r))
+	// Fails harmlessly if the client is gone
+	defer zw.Close()
+
+	if err := fs.addZipDir(zw, r, rc, dirPath, "", recursive); err != nil {
+		log.Printf("Stopped zip for %s: %v", dirPath, err)
+	}
+}
+
+// addZipDir adds the entries of the directory at dirPath to zw, their names
+// starting with prefix, and descends into subdirectories if recursive is
+// set. The directory is read like a listing, so with --overlay it is the
+// same merged view. An error returned means the archive should stop.
+func (fs *FileServer) addZipDir(zw *zip.Writer, r *http.Request, rc *http.ResponseController, dirPath, prefix string, recursive bool) error {
+	entries, err := fs.readDir(dirPath)
+	if err != nil {
+		log.Printf("Skipping %s in zip: %v", dirPath, err)
+		return nil
+	}
+	for _, entry := range entries {
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		// The same rules a direct request for the entry would meet: hidden
+		// names, the ACL, keys, share links and source maps
+		if fs.checkAccess(r, entry.path) != nil {
+			continue
+		}
+		name := prefix + entry.Name()
+		if entry.IsDir() {
+			if !recursive || !fs.depthAllowed(entry.path, true) {
+				continue
+			}
+			if err := fs.addZipDir(zw, r, rc, entry.path, name+"/", true); err != nil {
+				return err
+			}
+			continue
+		}
+		// Only regular files; symlinks could point outside the serve root
+		if !entry.Type().IsRegular() || !fs.extAllowed(entry.path, false) {
+			continue
+		}
+
+		if err := fs.addZipEntry(zw, entry.path, name); err != nil {
+			return err
+		}
+		if err := zw.Flush(); err != nil {
+			return err
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+	}
+	return nil
+}
+// addZipEntry copies the file at path into zw as name. A file that can't be
+// opened is logged and skipped. An error returned means the archive broke
+// off partway, usually because the client went away, so the caller should
+// stop.
+func (fs *FileServer) addZipEntry(zw *zip.Writer, path, name string) error {
+	file, err := fs.openFile(path)
+	if err != nil {
+		log.Printf("Skipping %s in zip: %v", path, err)
+		return nil
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		log.Printf("Skipping %s in zip: %v", path, err)
+		return nil
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		log.Printf("Skipping %s in zip: %v", path, err)
+		return nil
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	if !isCompressible(getMimeType(name)) {
+		header.Method = zip.Store
+	}
+
+	entry, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = fs.copy(entry, file)
+	return err
+}
+
+// wantsZip reports whether a directory request asks for a ZIP download.
+func wantsZip(r *http.Request) bool {
+	return strings.EqualFold(r.URL.Query().Get("download"), "zip")
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveUntilDisconnect starts fs behind a real listener, reads the first
+// KB of target and hangs up, then waits for the handler to return. It
+// fails the test if the handler or any goroutine it started outlives the
+// client.
+func serveUntilDisconnect(t *testing.T, fs *FileServer, target string) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		fs.ServeHTTP(w, r)
+	}))
+
+	resp, err := http.Get(srv.URL + target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(resp.Body, make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("handler still running after the client went away")
+	}
+	srv.Close()
+	http.DefaultClient.CloseIdleConnections()
+
+	// Let the server's and client's connection goroutines wind down
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		buf := make([]byte, 1<<16)
+		t.Errorf("%d goroutines before, %d after:\n%s", before, after, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+func TestZipDownloadStopsWhenClientDisconnects(t *testing.T) {
+	dir := t.TempDir()
+	// Incompressible, so the archive is far bigger than the socket buffers
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 64; i++ {
+		data := make([]byte, 1<<20)
+		random.Read(data)
+		if err := os.WriteFile(filepath.Join(dir, strconv.Itoa(i)+".bin"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	captureLog(t)
+	serveUntilDisconnect(t, newTestServer(dir), "/?download=zip")
+}
+
+func TestFollowStopsWhenClientDisconnects(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"app.log": string(make([]byte, 4096))})
+	fs := newTestServer(dir)
+	fs.allowFollow = true
+	serveUntilDisconnect(t, fs, "/app.log?follow=true")
+}
+
+// zipNames returns the entry names of the ZIP archive in body, sorted.
+func zipNames(t *testing.T, body []byte) []string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range zr.File {
+		names = append(names, file.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestZipDownloadSkipsProtectedEntries(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"public.txt": "p", "private/secret.txt": "s", "docs/private/deep.txt": "d", "docs/readme.txt": "r",
+	})
+	aclPath := filepath.Join(t.TempDir(), "acl.json")
+	writeACL(t, aclPath, `{"alice": "pw"}`, `[{"path": "/private", "users": ["alice"]}, {"path": "/docs/private", "users": ["alice"]}]`)
+	acl, err := loadACL(aclPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := newTestServer(dir)
+	fs.acl.Store(acl)
+
+	if w := doRequest(fs, http.MethodGet, "/private/secret.txt", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("direct GET: status %d, want 401", w.Code)
+	}
+	w := doRequest(fs, http.MethodGet, "/?download=zip&recursive=true", nil)
+	if got := strings.Join(zipNames(t, w.Body.Bytes()), " "); got != "docs/readme.txt public.txt" {
+		t.Errorf("anonymous archive holds %q", got)
+	}
+	w = doRequest(fs, http.MethodGet, "/?download=zip&recursive=true", nil, basicAuth("alice", "pw")...)
+	if got := strings.Join(zipNames(t, w.Body.Bytes()), " "); got != "docs/private/deep.txt docs/readme.txt private/secret.txt public.txt" {
+		t.Errorf("alice's archive holds %q", got)
+	}
+}
+
+func TestZipDownloadMergesOverlays(t *testing.T) {
+	base, overlay := t.TempDir(), t.TempDir()
+	writeFiles(t, base, map[string]string{"docs/a.txt": "base a", "docs/b.txt": "base b"})
+	writeFiles(t, overlay, map[string]string{"docs/b.txt": "overlay b", "docs/sub/c.txt": "overlay c", "top.txt": "t"})
+	fs := newTestServer(base)
+	fs.overlays = []string{overlay}
+
+	w := doRequest(fs, http.MethodGet, "/docs/?download=zip&recursive=true", nil)
+	body := w.Body.Bytes()
+	if got := strings.Join(zipNames(t, body), " "); got != "a.txt b.txt sub/c.txt" {
+		t.Fatalf("archive holds %q", got)
+	}
+	zr, _ := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	for _, file := range zr.File {
+		if file.Name != "b.txt" {
+			continue
+		}
+		rc, _ := file.Open()
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(content) != "overlay b" {
+			t.Errorf("b.txt holds %q, want the overlay's copy", content)
+		}
+	}
+
+	w = doRequest(fs, http.MethodGet, "/?download=zip", nil)
+	if got := strings.Join(zipNames(t, w.Body.Bytes()), " "); got != "top.txt" {
+		t.Errorf("non-recursive archive of the root holds %q", got)
+	}
+}